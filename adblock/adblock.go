@@ -0,0 +1,491 @@
+// Package adblock implements matching of network requests against Adblock Plus-style filter lists
+// (EasyList, EasyPrivacy, etc.). Only network filters are supported: element hiding rules, and
+// filters using options we cannot evaluate from request metadata alone, are skipped when parsing.
+package adblock
+
+import (
+	"bufio"
+	"errors"
+	"github.com/chromedp/cdproto/network"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Request types, named as they appear in filter options
+const (
+	TypeDocument       = "document"
+	TypeSubdocument    = "subdocument"
+	TypeScript         = "script"
+	TypeImage          = "image"
+	TypeStylesheet     = "stylesheet"
+	TypeObject         = "object"
+	TypeXMLHTTPRequest = "xmlhttprequest"
+	TypePing           = "ping"
+	TypeMedia          = "media"
+	TypeFont           = "font"
+	TypeWebSocket      = "websocket"
+	TypeOther          = "other"
+)
+
+var requestTypes = map[string]bool{
+	TypeDocument: true, TypeSubdocument: true, TypeScript: true, TypeImage: true, TypeStylesheet: true,
+	TypeObject: true, TypeXMLHTTPRequest: true, TypePing: true, TypeMedia: true, TypeFont: true,
+	TypeWebSocket: true, TypeOther: true,
+}
+
+// Matches element hiding, element hiding exception and snippet filters
+var cosmeticFilter = regexp.MustCompile(`#@?[?$%]?#`)
+
+// Matches "||example.com^", which we index by host rather than scanning
+var hostFilter = regexp.MustCompile(`^\|\|([a-z0-9.-]+)\^$`)
+
+// Filter is a single network filter parsed from a filter list
+type Filter struct {
+	Text      string // The filter as it appears in the list
+	List      string // Base name of the list the filter came from
+	Exception bool   // Whether this is an exception ("@@") filter
+
+	pattern *regexp.Regexp
+	keyword string // Lower-case literal which must appear in any URL the filter matches
+
+	types         map[string]bool
+	excludedTypes map[string]bool
+	thirdParty    *bool
+
+	domains         []string
+	excludedDomains []string
+}
+
+// Engine holds the filters from one or more filter lists
+type Engine struct {
+	hostFilters    map[string][]*Filter
+	filters        []*Filter
+	hostExceptions map[string][]*Filter
+	exceptions     []*Filter
+}
+
+// An engine in the cache, along with the state of the files it was built from
+type cachedEngine struct {
+	engine *Engine
+	stamp  string
+}
+
+var engineCache = make(map[string]*cachedEngine)
+var engineCacheLock sync.Mutex
+
+// Load parses the given filter lists into a single Engine. Since filter lists are large and the same
+// lists are generally used for every task, engines are cached by the set of files they were built from.
+// A cached engine is rebuilt if any of those files has changed (by modification time or size) since.
+func Load(files []string) (*Engine, error) {
+	key := strings.Join(files, "\x00")
+	stamp, err := filesStamp(files)
+	if err != nil {
+		return nil, err
+	}
+
+	engineCacheLock.Lock()
+	defer engineCacheLock.Unlock()
+	if cached, ok := engineCache[key]; ok && cached.stamp == stamp {
+		return cached.engine, nil
+	}
+
+	e := &Engine{
+		hostFilters:    make(map[string][]*Filter),
+		filters:        make([]*Filter, 0),
+		hostExceptions: make(map[string][]*Filter),
+		exceptions:     make([]*Filter, 0),
+	}
+	for _, fileName := range files {
+		err := e.addList(fileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	engineCache[key] = &cachedEngine{engine: e, stamp: stamp}
+	return e, nil
+}
+
+// filesStamp summarizes the modification time and size of each file, so we can tell when any of them change
+func filesStamp(files []string) (string, error) {
+	stamp := make([]string, 0, len(files))
+	for _, fileName := range files {
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return "", errors.New("failed to open filter list: " + err.Error())
+		}
+		stamp = append(stamp, strconv.FormatInt(info.ModTime().UnixNano(), 10)+":"+strconv.FormatInt(info.Size(), 10))
+	}
+
+	return strings.Join(stamp, ","), nil
+}
+
+func (e *Engine) addList(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return errors.New("failed to open filter list: " + err.Error())
+	}
+	defer f.Close()
+
+	// Some filters carry very long domain lists, so allow for lines longer than the default limit
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e.AddFilter(scanner.Text(), path.Base(fileName))
+	}
+
+	return scanner.Err()
+}
+
+// AddFilter parses a single line from a filter list and adds it to the engine. It returns false
+// if the line was a comment or a filter we do not support.
+func (e *Engine) AddFilter(line string, list string) bool {
+	filter, host := ParseFilter(line)
+	if filter == nil {
+		return false
+	}
+	filter.List = list
+
+	if filter.Exception {
+		if host != "" {
+			e.hostExceptions[host] = append(e.hostExceptions[host], filter)
+		} else {
+			e.exceptions = append(e.exceptions, filter)
+		}
+	} else {
+		if host != "" {
+			e.hostFilters[host] = append(e.hostFilters[host], filter)
+		} else {
+			e.filters = append(e.filters, filter)
+		}
+	}
+
+	return true
+}
+
+// ParseFilter parses a single line from a filter list. It returns nil if the line is a comment, an
+// element hiding rule or otherwise unsupported. If the filter only matches a host and its subdomains,
+// that host is also returned so the filter can be indexed.
+func ParseFilter(line string) (*Filter, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") || cosmeticFilter.MatchString(line) {
+		return nil, ""
+	}
+
+	filter := &Filter{Text: line}
+	if strings.HasPrefix(line, "@@") {
+		filter.Exception = true
+		line = line[2:]
+	}
+
+	// Regular expression filters may themselves contain "$", so they only have options if they don't end in "/"
+	pattern := line
+	options := ""
+	isRegex := strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") && len(line) > 1
+	if !isRegex {
+		if i := strings.LastIndex(line, "$"); i >= 0 {
+			pattern = line[:i]
+			options = line[i+1:]
+			isRegex = strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") && len(pattern) > 1
+		}
+	}
+
+	matchCase := false
+	if options != "" {
+		for _, option := range strings.Split(options, ",") {
+			name := strings.ToLower(option)
+			value := ""
+			if i := strings.Index(option, "="); i >= 0 {
+				name = strings.ToLower(option[:i])
+				value = option[i+1:]
+			}
+			negated := strings.HasPrefix(name, "~")
+			name = strings.TrimPrefix(name, "~")
+
+			switch {
+			case name == "match-case":
+				matchCase = true
+			case name == "third-party" || name == "3p":
+				thirdParty := !negated
+				filter.thirdParty = &thirdParty
+			case name == "first-party" || name == "1p":
+				thirdParty := negated
+				filter.thirdParty = &thirdParty
+			case name == "domain":
+				for _, d := range strings.Split(strings.ToLower(value), "|") {
+					if strings.HasPrefix(d, "~") {
+						filter.excludedDomains = append(filter.excludedDomains, d[1:])
+					} else if d != "" {
+						filter.domains = append(filter.domains, d)
+					}
+				}
+			case name == "xhr":
+				addType(filter, TypeXMLHTTPRequest, negated)
+			case name == "css":
+				addType(filter, TypeStylesheet, negated)
+			case name == "frame":
+				addType(filter, TypeSubdocument, negated)
+			case name == "doc":
+				addType(filter, TypeDocument, negated)
+			case requestTypes[name]:
+				addType(filter, name, negated)
+			case name == "important":
+				// We don't distinguish between exceptions and important filters, exceptions always win
+			default:
+				// Options like "popup", "csp" or "redirect" change what a filter does in ways we can't
+				// reproduce, so we skip the filter rather than treat it as a plain blocking filter
+				return nil, ""
+			}
+		}
+	}
+
+	var expr string
+	if isRegex {
+		expr = pattern[1 : len(pattern)-1]
+	} else {
+		expr, filter.keyword = patternToRegexp(pattern)
+	}
+	if !matchCase {
+		expr = "(?i)" + expr
+	}
+
+	var err error
+	filter.pattern, err = regexp.Compile(expr)
+	if err != nil {
+		return nil, ""
+	}
+
+	host := ""
+	if m := hostFilter.FindStringSubmatch(pattern); m != nil {
+		host = m[1]
+	}
+
+	return filter, host
+}
+
+func addType(filter *Filter, t string, negated bool) {
+	if negated {
+		if filter.excludedTypes == nil {
+			filter.excludedTypes = make(map[string]bool)
+		}
+		filter.excludedTypes[t] = true
+	} else {
+		if filter.types == nil {
+			filter.types = make(map[string]bool)
+		}
+		filter.types[t] = true
+	}
+}
+
+// patternToRegexp converts a filter pattern into a regular expression, along with the longest literal
+// segment of the pattern (which any matching URL must contain)
+func patternToRegexp(pattern string) (string, string) {
+	var sb strings.Builder
+	keyword := ""
+	current := ""
+
+	if strings.HasPrefix(pattern, "||") {
+		sb.WriteString(`^[a-z][a-z0-9+.-]*://([^/?#]*\.)?`)
+		pattern = pattern[2:]
+	} else if strings.HasPrefix(pattern, "|") {
+		sb.WriteString("^")
+		pattern = pattern[1:]
+	}
+
+	endAnchor := false
+	if strings.HasSuffix(pattern, "|") {
+		endAnchor = true
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '^':
+			sb.WriteString(`(?:[^\w.%-]|$)`)
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+			current += string(r)
+			continue
+		}
+
+		if len(current) > len(keyword) {
+			keyword = current
+		}
+		current = ""
+	}
+	if len(current) > len(keyword) {
+		keyword = current
+	}
+
+	if endAnchor {
+		sb.WriteString("$")
+	}
+
+	return sb.String(), strings.ToLower(keyword)
+}
+
+// RequestType maps a DevTools resource type to the request type used in filter options. Document
+// requests are either the top-level document or a subdocument (frame).
+func RequestType(rt network.ResourceType, topLevel bool) string {
+	switch rt {
+	case network.ResourceTypeDocument:
+		if topLevel {
+			return TypeDocument
+		}
+		return TypeSubdocument
+	case network.ResourceTypeScript:
+		return TypeScript
+	case network.ResourceTypeImage:
+		return TypeImage
+	case network.ResourceTypeStylesheet:
+		return TypeStylesheet
+	case network.ResourceTypeXHR, network.ResourceTypeFetch, network.ResourceTypeEventSource:
+		return TypeXMLHTTPRequest
+	case network.ResourceTypePing, network.ResourceTypeCSPViolationReport:
+		return TypePing
+	case network.ResourceTypeMedia, network.ResourceTypeTextTrack:
+		return TypeMedia
+	case network.ResourceTypeFont:
+		return TypeFont
+	case network.ResourceTypeWebSocket:
+		return TypeWebSocket
+	default:
+		return TypeOther
+	}
+}
+
+// Match finds the filter (if any) which matches a request of the given type, made from a document at documentURL.
+// If a blocking filter matches but an exception filter also matches, both are returned, and the request should
+// not be blocked.
+func (e *Engine) Match(requestURL string, documentURL string, requestType string) (*Filter, *Filter) {
+	req := newRequest(requestURL, documentURL, requestType)
+
+	block := req.find(e.hostFilters, e.filters)
+	if block == nil {
+		return nil, nil
+	}
+
+	return block, req.find(e.hostExceptions, e.exceptions)
+}
+
+// request holds the parsed details of a request which filters are matched against
+type request struct {
+	url          string
+	lowerURL     string
+	host         string
+	documentHost string
+	requestType  string
+	thirdParty   bool
+}
+
+func newRequest(requestURL string, documentURL string, requestType string) *request {
+	req := &request{
+		url:         requestURL,
+		lowerURL:    strings.ToLower(requestURL),
+		requestType: requestType,
+	}
+
+	if u, err := url.Parse(requestURL); err == nil {
+		req.host = strings.ToLower(u.Hostname())
+	}
+	if u, err := url.Parse(documentURL); err == nil {
+		req.documentHost = strings.ToLower(u.Hostname())
+	}
+	if req.documentHost == "" {
+		req.documentHost = req.host
+	}
+	req.thirdParty = site(req.host) != site(req.documentHost)
+
+	return req
+}
+
+func (req *request) find(hostIndex map[string][]*Filter, filters []*Filter) *Filter {
+	// Check the host and each of its parent domains against the index
+	host := req.host
+	for host != "" {
+		for _, f := range hostIndex[host] {
+			if req.matches(f) {
+				return f
+			}
+		}
+		i := strings.Index(host, ".")
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+
+	for _, f := range filters {
+		if req.matches(f) {
+			return f
+		}
+	}
+
+	return nil
+}
+
+func (req *request) matches(f *Filter) bool {
+	if f.keyword != "" && !strings.Contains(req.lowerURL, f.keyword) {
+		return false
+	}
+
+	if f.types != nil {
+		if !f.types[req.requestType] {
+			return false
+		}
+	} else if req.requestType == TypeDocument {
+		// Filters without type options never apply to the top-level document itself
+		return false
+	}
+	if f.excludedTypes[req.requestType] {
+		return false
+	}
+
+	if f.thirdParty != nil && *f.thirdParty != req.thirdParty {
+		return false
+	}
+
+	if len(f.domains) > 0 {
+		found := false
+		for _, d := range f.domains {
+			if isSubdomain(req.documentHost, d) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, d := range f.excludedDomains {
+		if isSubdomain(req.documentHost, d) {
+			return false
+		}
+	}
+
+	return f.pattern.MatchString(req.url)
+}
+
+// isSubdomain returns true if host is domain, or a subdomain of it
+func isSubdomain(host string, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// site approximates the registrable domain of a host with its last two labels. This is wrong
+// for hosts under multi-label public suffixes (e.g., "co.uk"), but good enough to tell first
+// parties from third parties in the vast majority of cases.
+func site(host string) string {
+	if strings.Count(host, ".") < 2 || strings.Trim(host, "0123456789.") == "" || strings.Contains(host, ":") {
+		return host
+	}
+
+	labels := strings.Split(host, ".")
+	return strings.Join(labels[len(labels)-2:], ".")
+}
@@ -0,0 +1,115 @@
+package adblock
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// TestMatch checks a handful of common filter syntaxes against requests
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	e := &Engine{
+		hostFilters:    make(map[string][]*Filter),
+		hostExceptions: make(map[string][]*Filter),
+	}
+	for _, line := range []string{
+		"! Comment",
+		"example.com##.ad",
+		"||ads.example.net^",
+		"/banner/*/img^",
+		"||tracker.org^$third-party",
+		"||cdn.example.org/ads.js$script,domain=news.com|~sports.news.com",
+		"@@||ads.example.net/allowed^",
+	} {
+		e.AddFilter(line, "test.txt")
+	}
+
+	cases := []struct {
+		url         string
+		document    string
+		requestType string
+		filter      string
+		exception   bool
+	}{
+		{"https://ads.example.net/x.js", "https://site.com/", TypeScript, "||ads.example.net^", false},
+		{"https://sub.ads.example.net/x.js", "https://site.com/", TypeScript, "||ads.example.net^", false},
+		{"https://badsads.example.net/x.js", "https://site.com/", TypeScript, "", false},
+		{"https://ads.example.net/allowed/x.js", "https://site.com/", TypeScript, "||ads.example.net^", true},
+		{"https://site.com/banner/foo/img?x=1", "https://site.com/", TypeImage, "/banner/*/img^", false},
+		{"https://site.com/banner/foo/imgs", "https://site.com/", TypeImage, "", false},
+		{"https://tracker.org/t.gif", "https://site.com/", TypeImage, "||tracker.org^$third-party", false},
+		{"https://tracker.org/t.gif", "https://www.tracker.org/", TypeImage, "", false},
+		{"https://cdn.example.org/ads.js", "https://www.news.com/", TypeScript,
+			"||cdn.example.org/ads.js$script,domain=news.com|~sports.news.com", false},
+		{"https://cdn.example.org/ads.js", "https://sports.news.com/", TypeScript, "", false},
+		{"https://cdn.example.org/ads.js", "https://www.news.com/", TypeImage, "", false},
+		{"https://ads.example.net/", "https://ads.example.net/", TypeDocument, "", false},
+	}
+
+	for _, c := range cases {
+		block, exception := e.Match(c.url, c.document, c.requestType)
+		if c.filter == "" {
+			if block != nil {
+				t.Errorf("%s unexpectedly matched %s", c.url, block.Text)
+			}
+			continue
+		}
+		if block == nil || block.Text != c.filter {
+			t.Errorf("%s did not match %s", c.url, c.filter)
+			continue
+		}
+		if (exception != nil) != c.exception {
+			t.Errorf("%s: unexpected exception result", c.url)
+		}
+	}
+}
+
+// TestLoadReloadsChangedLists checks that a cached engine is rebuilt once its filter list changes
+func TestLoadReloadsChangedLists(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "mida-adblock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	list := path.Join(dir, "list.txt")
+
+	err = ioutil.WriteFile(list, []byte("||old.example.com^\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Load([]string{list})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := e.Match("https://old.example.com/", "https://site.com/", TypeScript); block == nil {
+		t.Fatal("filter list was not loaded")
+	}
+
+	err = ioutil.WriteFile(list, []byte("||new.example.com^\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the change shows up, even on filesystems with coarse modification times
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(list, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = Load([]string{list})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := e.Match("https://old.example.com/", "https://site.com/", TypeScript); block != nil {
+		t.Fatal("stale filter list was served from the cache")
+	}
+	if block, _ := e.Match("https://new.example.com/", "https://site.com/", TypeScript); block == nil {
+		t.Fatal("changed filter list was not reloaded")
+	}
+}
@@ -7,12 +7,20 @@ import (
 	"errors"
 	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/debugger"
+	"github.com/chromedp/cdproto/domsnapshot"
+	"github.com/chromedp/cdproto/har"
+	"github.com/chromedp/cdproto/indexeddb"
+	cdplog "github.com/chromedp/cdproto/log"
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/profiler"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/cdproto/target"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
+	"regexp"
 	"sync"
 	"time"
 )
@@ -23,6 +31,8 @@ type InteractionSettings struct {
 	BasicInteraction      *bool `json:"basic_interaction"`
 	Gremlins              *bool `json:"gremlins"`
 	TriggerEventListeners *bool `json:"event_listeners"`
+	FollowPopups          *bool `json:"follow_popups"` // Keep popups open and record them, rather than closing them
+	MaxPopups             *int  `json:"max_popups"`    // Maximum number of popups to follow (any others are closed)
 }
 
 // Settings describing the way in which a browser will be opened
@@ -34,6 +44,88 @@ type BrowserSettings struct {
 	SetBrowserFlags     *[]string            `json:"set_browser_flags,omitempty"`    // Flags to use to override default browser flags
 	Extensions          *[]string            `json:"extensions,omitempty"`           // Paths to browser extensions to be used for the crawl
 	InteractionSettings *InteractionSettings `json:"interaction_settings"`           // Settings describing how the browser will interact with the page
+
+	FilterLists        *[]string `json:"filter_lists,omitempty"`         // Paths to Adblock Plus-style filter lists (e.g., EasyList) used to label requests
+	BlockFilterMatches *bool     `json:"block_filter_matches,omitempty"` // Whether to block requests matching the filter lists
+
+	Emulation *EmulationSettings `json:"emulation,omitempty"` // Device and environment to emulate
+
+	ExtraHTTPHeaders *map[string]string `json:"extra_http_headers,omitempty"` // Headers added to every request the browser sends
+	UserAgent        *string            `json:"user_agent,omitempty"`         // User agent to send (overrides any from the emulated device)
+	AcceptLanguage   *string            `json:"accept_language,omitempty"`    // Accept-Language header to send (e.g., "de-DE,de;q=0.9")
+	ClientHints      *ClientHints       `json:"client_hints,omitempty"`       // User agent client hints to send along with the user agent
+
+	Proxy *ProxySettings `json:"proxy,omitempty"` // Proxy to route the browser's traffic through
+
+	InitScripts *[]string `json:"init_scripts,omitempty"` // Paths to JavaScript files run in every frame before any page script
+}
+
+// Settings for a proxy which the browser sends all its traffic through
+type ProxySettings struct {
+	URL      *string   `json:"url,omitempty"`      // Proxy server (e.g., "http://proxy.example.com:8080" or "socks5://127.0.0.1:1080")
+	Bypass   *[]string `json:"bypass,omitempty"`   // Hosts to connect to directly (e.g., "*.example.com" or "10.0.0.0/8")
+	Username *string   `json:"username,omitempty"` // Username for proxy authentication (HTTP(S) proxies only)
+	Password *string   `json:"password,omitempty"` // Password for proxy authentication (HTTP(S) proxies only)
+}
+
+// Settings describing the device and environment the browser emulates. Any settings given
+// explicitly override those of the named device profile.
+type EmulationSettings struct {
+	Device            *string      `json:"device,omitempty"`              // Name of a device profile (see DefaultDeviceProfiles)
+	Width             *int64       `json:"width,omitempty"`               // Viewport width, in CSS pixels
+	Height            *int64       `json:"height,omitempty"`              // Viewport height, in CSS pixels
+	DeviceScaleFactor *float64     `json:"device_scale_factor,omitempty"` // Ratio of device pixels to CSS pixels
+	Mobile            *bool        `json:"mobile,omitempty"`              // Emulate a mobile device (meta viewport, overlay scrollbars, etc.)
+	Touch             *bool        `json:"touch,omitempty"`               // Emulate a touch screen
+	UserAgent         *string      `json:"user_agent,omitempty"`          // User agent string
+	ClientHints       *ClientHints `json:"client_hints,omitempty"`        // User agent client hints, sent along with the user agent
+	Timezone          *string      `json:"timezone,omitempty"`            // IANA timezone ID (e.g., "America/Chicago")
+	Locale            *string      `json:"locale,omitempty"`              // ICU locale (e.g., "en_US")
+	Geolocation       *Geolocation `json:"geolocation,omitempty"`         // Position reported by the Geolocation API
+}
+
+// User agent client hints (Sec-CH-UA-*) to accompany an emulated user agent
+type ClientHints struct {
+	Brands          []ClientHintBrand `json:"brands"`
+	Platform        string            `json:"platform"`
+	PlatformVersion string            `json:"platform_version"`
+	Architecture    string            `json:"architecture"`
+	Model           string            `json:"model"`
+}
+
+type ClientHintBrand struct {
+	Brand   string `json:"brand"`
+	Version string `json:"version"`
+}
+
+// A position to report through the Geolocation API
+type Geolocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy"` // In meters
+}
+
+// The emulation settings which will actually be applied for a task, after applying the device profile
+type SanitizedEmulation struct {
+	Device            string       `json:"device,omitempty"`
+	Width             int64        `json:"width,omitempty"` // Zero if we are not overriding the viewport
+	Height            int64        `json:"height,omitempty"`
+	DeviceScaleFactor float64      `json:"device_scale_factor,omitempty"`
+	Mobile            bool         `json:"mobile"`
+	Touch             bool         `json:"touch"`
+	UserAgent         string       `json:"user_agent,omitempty"`
+	ClientHints       *ClientHints `json:"client_hints,omitempty"`
+	Timezone          string       `json:"timezone,omitempty"`
+	Locale            string       `json:"locale,omitempty"`
+	Geolocation       *Geolocation `json:"geolocation,omitempty"`
+}
+
+// The proxy settings which will actually be used for a task. The password is never written out with results.
+type SanitizedProxy struct {
+	URL      string   `json:"url"` // Scheme, host and port only (credentials are kept separately)
+	Bypass   []string `json:"bypass,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"-"`
 }
 
 // Conditions under which a crawl will complete successfully
@@ -54,6 +146,70 @@ type CompletionSettings struct {
 	TimeAfterLoad       *int                 `json:"time_after_load,omitempty"` // Maximum amount of time the browser will remain open after page load
 }
 
+// Actions which may be taken on a request matching a RequestRule
+type RequestRuleAction string
+
+const (
+	RequestRuleBlock          RequestRuleAction = "block"           // Fail the request as if it were blocked by the client
+	RequestRuleContinue       RequestRuleAction = "continue"        // Let the request proceed unmodified
+	RequestRuleRewriteHeaders RequestRuleAction = "rewrite_headers" // Let the request proceed with modified request headers
+	RequestRuleFulfill        RequestRuleAction = "fulfill"         // Respond to the request with the contents of a local file
+)
+
+var RequestRuleActions = [...]RequestRuleAction{RequestRuleBlock, RequestRuleContinue, RequestRuleRewriteHeaders, RequestRuleFulfill}
+
+// A rule describing how the browser should handle requests matching certain criteria. Rules are applied in order,
+// and only the first matching rule is applied to a given request.
+type RequestRule struct {
+	Name           *string            `json:"name,omitempty"`            // Name used to identify the rule in results (defaults to its index)
+	URLGlob        *string            `json:"url_glob,omitempty"`        // Glob ("*" and "?" wildcards) which request URLs must match
+	URLRegex       *string            `json:"url_regex,omitempty"`       // Regular expression which request URLs must match
+	ResourceTypes  *[]string          `json:"resource_types,omitempty"`  // Resource types (e.g., "Script") the rule applies to. All types if empty.
+	Action         *RequestRuleAction `json:"action"`                    // What to do with matching requests
+	Headers        *map[string]string `json:"headers,omitempty"`         // Headers to set for rewrite_headers (an empty value removes the header)
+	FulfillFile    *string            `json:"fulfill_file,omitempty"`    // File containing the response body for fulfill
+	FulfillStatus  *int               `json:"fulfill_status,omitempty"`  // HTTP status code for fulfill (defaults to 200)
+	FulfillHeaders *map[string]string `json:"fulfill_headers,omitempty"` // Response headers for fulfill
+}
+
+// A cookie to install in the browser before we navigate to the page
+type SeedCookie struct {
+	Name     *string  `json:"name"`
+	Value    *string  `json:"value"`
+	Domain   *string  `json:"domain,omitempty"`    // Cookie domain (e.g., ".example.com"). A host-only cookie for the task URL if omitted.
+	Path     *string  `json:"path,omitempty"`      // Defaults to "/"
+	Secure   *bool    `json:"secure,omitempty"`    // Only send the cookie over secure connections
+	HTTPOnly *bool    `json:"http_only,omitempty"` // Hide the cookie from scripts
+	SameSite *string  `json:"same_site,omitempty"` // "Strict", "Lax" or "None"
+	Expires  *float64 `json:"expires,omitempty"`   // Expiration, in seconds since the Unix epoch (a session cookie if omitted)
+}
+
+// A JavaScript expression to evaluate after the load event. Its result is stored under its name.
+type PostLoadScript struct {
+	Name       *string `json:"name"`
+	Expression *string `json:"expression"` // May evaluate to a promise, in which case we wait for it to settle
+}
+
+// The outcome of evaluating a post-load script
+type ScriptResult struct {
+	Result    json.RawMessage `json:"result,omitempty"`    // Value the expression evaluated (or its promise resolved) to, as JSON
+	Exception string          `json:"exception,omitempty"` // Description of the exception thrown (or the promise rejection), if any
+	Error     string          `json:"error,omitempty"`     // Why we could not evaluate the expression at all, if we could not
+}
+
+// A RequestRule which has been validated and compiled, ready to be matched against requests
+type SanitizedRequestRule struct {
+	Name          string
+	URLPattern    *regexp.Regexp // Compiled from either the glob or the regular expression in the raw rule
+	ResourceTypes []string
+	Action        RequestRuleAction
+
+	Headers        map[string]string
+	FulfillFile    string
+	FulfillStatus  int
+	FulfillHeaders map[string]string
+}
+
 // Settings describing which data MIDA will capture from the crawl
 type DataSettings struct {
 	AllResources     *bool `json:"all_resources,omitempty"`     // Save all resource files
@@ -63,6 +219,28 @@ type DataSettings struct {
 	ResourceMetadata *bool `json:"resource_metadata,omitempty"` // Save extensive metadata about each resource
 	Screenshot       *bool `json:"screenshot,omitempty"`        // Save a screenshot from the web page
 	ScriptMetadata   *bool `json:"script_metadata,omitempty"`   // Save metadata on scripts parsed by browser
+	WebSocket        *bool `json:"websocket,omitempty"`         // Save websocket connections, handshakes and frames
+
+	EventSourceMessages *bool `json:"event_source_messages,omitempty"` // Save messages received over Server-Sent Events streams
+	HAR                 *bool `json:"har,omitempty"`                   // Save a HAR 1.2 archive of all network traffic
+	WARC                *bool `json:"warc,omitempty"`                  // Save a WARC archive of all requests and responses
+	Console             *bool `json:"console,omitempty"`               // Save console messages, uncaught exceptions and browser log entries
+	JSCoverage          *bool `json:"js_coverage,omitempty"`           // Gather precise JavaScript coverage, stored with script metadata
+	Screencast          *bool `json:"screencast,omitempty"`            // Record a screencast of the whole site visit
+	ScreencastGIF       *bool `json:"screencast_gif,omitempty"`        // Assemble the screencast frames into an animated GIF
+	MHTML               *bool `json:"mhtml,omitempty"`                 // Save a self-contained MHTML snapshot of the page
+
+	DOMSnapshot       *bool     `json:"dom_snapshot,omitempty"`        // Collect a DOM snapshot with layout, paint order and visibility of each element
+	DOMSnapshotStyles *[]string `json:"dom_snapshot_styles,omitempty"` // Computed styles to include in the DOM snapshot
+
+	WebStorage *bool `json:"web_storage,omitempty"` // Save localStorage, sessionStorage and IndexedDB contents at the end of the visit
+	Frames     *bool `json:"frames,omitempty"`      // Save the frame tree, including the requests made by each frame
+	Downloads  *bool `json:"downloads,omitempty"`   // Save files downloaded by the page
+
+	ScreenshotFullPage *bool   `json:"screenshot_full_page,omitempty"` // Capture the full page rather than just the viewport
+	ScreenshotInterval *int    `json:"screenshot_interval,omitempty"`  // Seconds between screenshots after load, until the browser closes (0 for a single screenshot)
+	ScreenshotFormat   *string `json:"screenshot_format,omitempty"`    // Image format for screenshots ("png", "jpeg" or "webp")
+	ScreenshotQuality  *int    `json:"screenshot_quality,omitempty"`   // Compression quality (0-100) for jpeg and webp screenshots
 
 	BrowserCoverage *bool `json:"browser_coverage"` // Whether to gather code coverage data from the browser
 	RawCovFiles     *bool `json:"raw_cov_files"`    // Raw profraw files from browser
@@ -103,6 +281,37 @@ type RawTask struct {
 	Completion *CompletionSettings `json:"completion_settings"` // Settings for when the site visit will complete
 	Data       *DataSettings       `json:"data_settings"`       // Settings for what data will be collected from the site
 	Output     *OutputSettings     `json:"output_settings"`     // Settings for what/how results will be saved
+
+	RequestRules *[]RequestRule `json:"request_rules,omitempty"` // Rules for blocking or modifying requests made by the browser
+	Replay       *string        `json:"replay,omitempty"`        // Results directory from an earlier crawl, used to replay responses instead of using the network
+
+	NetworkConditions *NetworkConditions `json:"network_conditions,omitempty"` // Network (and CPU) conditions to emulate
+
+	SeedCookies      *[]SeedCookie                 `json:"seed_cookies,omitempty"`       // Cookies to install in the browser before navigation
+	SeedLocalStorage *map[string]map[string]string `json:"seed_local_storage,omitempty"` // localStorage items to install before navigation, keyed by origin
+
+	PostLoadScripts *[]PostLoadScript `json:"post_load_scripts,omitempty"` // Named JavaScript expressions to evaluate after the load event
+}
+
+// Network (and CPU) conditions to emulate for a task. Any settings given explicitly
+// override those of the named profile.
+type NetworkConditions struct {
+	Profile            *string  `json:"profile,omitempty"`             // Name of a network profile (see DefaultNetworkProfiles)
+	Offline            *bool    `json:"offline,omitempty"`             // Emulate a lack of internet connectivity
+	Latency            *float64 `json:"latency,omitempty"`             // Minimum latency added to each request, in milliseconds
+	DownloadThroughput *float64 `json:"download_throughput,omitempty"` // Maximum download throughput, in kilobits per second (0 is unlimited)
+	UploadThroughput   *float64 `json:"upload_throughput,omitempty"`   // Maximum upload throughput, in kilobits per second (0 is unlimited)
+	CPUThrottlingRate  *float64 `json:"cpu_throttling_rate,omitempty"` // Factor by which the CPU is slowed down (1 is no throttling)
+}
+
+// The network conditions which will actually be applied for a task, after applying the profile
+type SanitizedNetworkConditions struct {
+	Profile            string  `json:"profile,omitempty"`
+	Offline            bool    `json:"offline"`
+	Latency            float64 `json:"latency"`
+	DownloadThroughput float64 `json:"download_throughput"`
+	UploadThroughput   float64 `json:"upload_throughput"`
+	CPUThrottlingRate  float64 `json:"cpu_throttling_rate"`
 }
 
 // Internal type built from the process of sanitizing a RawTask. Should contain all the parameters needed for a crawl
@@ -119,6 +328,28 @@ type SanitizedTask struct {
 	DS  DataSettings        // Data Gathering Settings for the task
 	IS  InteractionSettings // Settings on how the browser will interact with the page
 	OPS OutputSettings      // Output settings for the task
+
+	RequestRules []SanitizedRequestRule // Rules for blocking or modifying requests, in the order they are applied
+
+	FilterLists        []string // Full paths to the filter lists used to label (and possibly block) requests
+	BlockFilterMatches bool     // Whether requests matching the filter lists are blocked
+
+	ReplayDirectory string // Full path to the results directory we replay responses from (empty if not replaying)
+
+	Emulation         *SanitizedEmulation         // Device and environment to emulate (nil if we are not emulating anything)
+	NetworkConditions *SanitizedNetworkConditions // Network conditions to emulate (nil if we are using the network as-is)
+	Proxy             *SanitizedProxy             // Proxy to route the browser's traffic through (nil for a direct connection)
+
+	SeedCookies      []*network.CookieParam       // Cookies to install in the browser before navigation
+	SeedLocalStorage map[string]map[string]string // localStorage items to install before navigation, keyed by origin
+
+	InitScripts     []string         // Full paths to JavaScript files run in every frame before any page script
+	PostLoadScripts []PostLoadScript // Named JavaScript expressions to evaluate after the load event, in order
+
+	ExtraHTTPHeaders map[string]string // Headers added to every request the browser sends
+	UserAgent        string            // User agent to send, from the browser settings or emulated device (empty for the browser default)
+	AcceptLanguage   string            // Accept-Language header to send (empty for the browser default)
+	ClientHints      *ClientHints      // User agent client hints to send along with the user agent (nil for the browser default)
 }
 
 // A slice of MIDA tasks, ready to be enqueued
@@ -133,6 +364,16 @@ type CompressedTaskSet struct {
 	Data       *DataSettings       `json:"data_settings"`       // Settings for what data will be collected from the site
 	Output     *OutputSettings     `json:"output_settings"`     // Settings for what/how results will be saved
 
+	RequestRules *[]RequestRule `json:"request_rules,omitempty"` // Rules for blocking or modifying requests made by the browser
+	Replay       *string        `json:"replay,omitempty"`        // Results directory from an earlier crawl, used to replay responses instead of using the network
+
+	NetworkConditions *NetworkConditions `json:"network_conditions,omitempty"` // Network (and CPU) conditions to emulate
+
+	SeedCookies      *[]SeedCookie                 `json:"seed_cookies,omitempty"`       // Cookies to install in the browser before navigation
+	SeedLocalStorage *map[string]map[string]string `json:"seed_local_storage,omitempty"` // localStorage items to install before navigation, keyed by origin
+
+	PostLoadScripts *[]PostLoadScript `json:"post_load_scripts,omitempty"` // Named JavaScript expressions to evaluate after the load event
+
 	Repeat *int `json:"repeat"` // Number of times to repeat the crawl after it finishes successfully
 }
 
@@ -168,6 +409,31 @@ type BrowserCoverageMetadata struct {
 	CoveredRegions       int      `json:"covered_regions""`
 }
 
+// Counts of the console output gathered from a site visit
+type ConsoleMetadata struct {
+	NumConsoleAPICalls int            `json:"num_console_api_calls"`  // Number of calls to console.* (log, error, etc.)
+	NumExceptions      int            `json:"num_exceptions"`         // Number of uncaught JavaScript exceptions
+	NumLogEntries      int            `json:"num_log_entries"`        // Number of entries added to the browser log
+	ConsoleAPICallType map[string]int `json:"console_api_call_types"` // Number of console API calls of each type (e.g., "warning")
+	LogEntryLevels     map[string]int `json:"log_entry_levels"`       // Number of browser log entries at each level (e.g., "error")
+}
+
+// Statistics on a site visit replayed from earlier results
+type ReplayMetadata struct {
+	Directory    string   `json:"directory"`     // Results directory responses were replayed from
+	NumFulfilled int      `json:"num_fulfilled"` // Number of requests fulfilled from recorded responses
+	Misses       []string `json:"misses"`        // URLs of requests which had no recorded counterpart
+}
+
+// Counts of the web storage entries found at the end of a site visit
+type WebStorageMetadata struct {
+	NumOrigins             int `json:"num_origins"`               // Number of origins whose storage we gathered
+	NumLocalStorageItems   int `json:"num_local_storage_items"`   // Total localStorage items across all origins
+	NumSessionStorageItems int `json:"num_session_storage_items"` // Total sessionStorage items across all origins
+	NumIndexedDBDatabases  int `json:"num_indexeddb_databases"`   // Total IndexedDB databases across all origins
+	NumIndexedDBEntries    int `json:"num_indexeddb_entries"`     // Total IndexedDB entries across all object stores (before caps)
+}
+
 // Statistics gathered about a specific task
 type TaskSummary struct {
 	NavURL string `json:"nav_url"`
@@ -185,10 +451,18 @@ type TaskSummary struct {
 
 	NumResources int `json:"num_resources"` // Number of resources the browser downloaded
 	NumScripts   int `json:"num_scripts"`   // Number of scripts the browser parsed
+	NumPopups    int `json:"num_popups"`    // Number of popups we followed
 
 	NavHistory []page.NavigationEntry `json:"nav_history"`
 
 	BrowserCovData BrowserCoverageMetadata `json:"browser_cov_data"`
+	ConsoleData    ConsoleMetadata         `json:"console_data"`
+	ReplayData     *ReplayMetadata         `json:"replay_data,omitempty"`
+	WebStorageData *WebStorageMetadata     `json:"web_storage_data,omitempty"`
+
+	NetworkConditions *SanitizedNetworkConditions `json:"network_conditions,omitempty"` // Network conditions emulated during the visit
+	ScriptResults     map[string]*ScriptResult    `json:"script_results,omitempty"`     // Results of the post-load scripts, keyed by name
+	Proxy             *SanitizedProxy             `json:"proxy,omitempty"`              // Proxy the browser's traffic went through
 }
 
 // Information about the infrastructure used to perform the crawl
@@ -197,20 +471,86 @@ type CrawlerInfo struct {
 	BrowserVersion string `json:"browser_version"` // Version of the browser we are using
 	UserAgent      string `json:"user_agent"`      // User agent we are using
 	JSVersion      string `json:"js_version"`      // JS version
+
+	Emulation *SanitizedEmulation `json:"emulation,omitempty"` // Device and environment the browser emulated
 }
 
 type DevToolsNetworkRawData struct {
 	RequestWillBeSent map[string][]*network.EventRequestWillBeSent
 	ResponseReceived  map[string]*network.EventResponseReceived
+	LoadingFinished   map[string]*network.EventLoadingFinished
+
+	EventSourceMessageReceived map[string][]*network.EventEventSourceMessageReceived
+
+	FilterMatches map[string]*DTFilterMatch // Filter list matches, keyed by request ID
+	FilterBlocked map[string]bool           // Requests blocked because they matched a filter list, keyed by request ID
 }
 
 type DevToolsScriptRawData []*debugger.EventScriptParsed
 
+// The data gathered from a single popup, before post-processing
+type PopupRawResult struct {
+	Index         int
+	TargetID      string
+	OpenerID      string
+	OpenerFrameID string
+	InitialURL    string
+	Opened        time.Time
+	Network       DevToolsNetworkRawData
+	NavHistory    []page.NavigationEntry
+	Screenshot    string // Path to the popup's screenshot within the temp directory, if we took one
+}
+
+// Frame events, in the order they were received. Cross-origin frames which run in their own process
+// navigate within their own targets, so we follow those through changes to their target info.
+type DevToolsFrameRawData struct {
+	Attached      []*page.EventFrameAttached
+	Navigated     []*page.EventFrameNavigated
+	Detached      []*page.EventFrameDetached
+	IframeTargets []*target.Info
+}
+
+type DevToolsDOMSnapshotRawData struct {
+	Documents []*domsnapshot.DocumentSnapshot
+	Strings   []string
+}
+
+type DevToolsJSCoverageRawData []*profiler.ScriptCoverage
+
+type DevToolsWebSocketRawData struct {
+	Created           map[string]*network.EventWebSocketCreated
+	HandshakeRequest  map[string]*network.EventWebSocketWillSendHandshakeRequest
+	HandshakeResponse map[string]*network.EventWebSocketHandshakeResponseReceived
+	Frames            map[string][]*DTWebSocketFrame
+	FrameErrors       map[string][]*network.EventWebSocketFrameError
+	Closed            map[string]*network.EventWebSocketClosed
+}
+
+// Console output gathered from a site visit, in the order in which it occurred
+type DTConsole struct {
+	ConsoleAPICalls []*runtime.EventConsoleAPICalled `json:"console_api_calls"` // Calls to console.* made by the page
+	Exceptions      []*runtime.EventExceptionThrown  `json:"exceptions"`        // Uncaught exceptions thrown by the page
+	LogEntries      []*cdplog.EventEntryAdded        `json:"log_entries"`       // Entries added to the browser log (network errors, interventions, etc.)
+}
+
 type DevToolsRawData struct {
-	Network DevToolsNetworkRawData
-	Cookies []*network.Cookie
-	DOM     *cdp.Node
-	Scripts DevToolsScriptRawData
+	Network         DevToolsNetworkRawData
+	Cookies         []*network.Cookie
+	DOM             *cdp.Node
+	Scripts         DevToolsScriptRawData
+	WebSockets      DevToolsWebSocketRawData
+	EventListeners  []*DTEventListener
+	Console         DTConsole
+	JSCoverage      DevToolsJSCoverageRawData
+	RequestRuleHits []*DTRequestRuleHit
+	Screencast      []*DTScreencastFrame
+	MHTML           string
+	DOMSnapshot     *DevToolsDOMSnapshotRawData
+	WebStorage      map[string]*DTOriginStorage
+	Frames          DevToolsFrameRawData
+	Popups          []*PopupRawResult
+	Downloads       map[string]*DTDownload
+	Instrumentation []*DTInstrumentationRecord
 }
 
 // The results MIDA gathers before they are post-processed
@@ -223,14 +563,285 @@ type RawResult struct {
 type DTResource struct {
 	Requests []*network.EventRequestWillBeSent `json:"requests"`  // All requests sent for this particular request
 	Response *network.EventResponseReceived    `json:"responses"` // All responses received for this particular request
+
+	EventSourceMessages []*network.EventEventSourceMessageReceived `json:"event_source_messages,omitempty"` // Server-Sent Events received on this request
+	FilterMatch         *DTFilterMatch                             `json:"filter_match,omitempty"`          // Filter list rule matching this request, if any
+	FrameID             string                                     `json:"frame_id,omitempty"`              // Frame which made this request (see frames.json)
+}
+
+// Direction of a websocket frame, from the perspective of the browser
+type WebSocketFrameDirection string
+
+const (
+	WebSocketFrameSent     WebSocketFrameDirection = "sent"
+	WebSocketFrameReceived WebSocketFrameDirection = "received"
+)
+
+// A single websocket frame (message), sent or received by the browser
+type DTWebSocketFrame struct {
+	Direction   WebSocketFrameDirection `json:"direction"`    // Whether the browser sent or received the frame
+	Timestamp   *cdp.MonotonicTime      `json:"timestamp"`    // Time at which the frame was sent or received
+	Opcode      float64                 `json:"opcode"`       // WebSocket message opcode
+	Mask        bool                    `json:"mask"`         // WebSocket message mask
+	PayloadData string                  `json:"payload_data"` // Text payload if opcode is 1, otherwise base64-encoded binary data
+}
+
+type DTWebSocket struct {
+	URL               string                                           `json:"url"`                          // URL of the websocket
+	Initiator         *network.Initiator                               `json:"initiator,omitempty"`          // Initiator of the websocket connection
+	HandshakeRequest  *network.EventWebSocketWillSendHandshakeRequest  `json:"handshake_request,omitempty"`  // Handshake request sent by the browser
+	HandshakeResponse *network.EventWebSocketHandshakeResponseReceived `json:"handshake_response,omitempty"` // Handshake response received from the server
+	Frames            []*DTWebSocketFrame                              `json:"frames"`                       // All frames sent and received, in the order we saw them
+	FrameErrors       []*network.EventWebSocketFrameError              `json:"frame_errors,omitempty"`       // Errors which occurred on the websocket
+	Closed            *cdp.MonotonicTime                               `json:"closed,omitempty"`             // Time at which the websocket was closed, if it was
+}
+
+// Metadata on a script parsed by the browser, along with its coverage (if gathered)
+type DTScript struct {
+	*debugger.EventScriptParsed
+	Coverage *DTScriptCoverage `json:"coverage,omitempty"`
+}
+
+// MarshalJSON writes out the script as the browser reports it, with its coverage (if any) alongside
+// its fields. Without it, the JSON methods of the embedded event would be used and coverage would be lost.
+func (s DTScript) MarshalJSON() ([]byte, error) {
+	extra := make(map[string]interface{})
+	if s.Coverage != nil {
+		extra["coverage"] = s.Coverage
+	}
+	return marshalWithFields(s.EventScriptParsed, extra)
+}
+
+// Precise JavaScript coverage for a single script
+type DTScriptCoverage struct {
+	Functions   []*profiler.FunctionCoverage `json:"functions"`    // Per-function block coverage, as reported by the browser
+	TotalBytes  int64                        `json:"total_bytes"`  // Length of the script
+	UsedBytes   int64                        `json:"used_bytes"`   // Number of bytes of the script which executed at least once
+	PercentUsed float64                      `json:"percent_used"` // Percentage of the script which executed at least once
+}
+
+// An entry in the index of screenshots taken during a site visit
+type ScreenshotIndexEntry struct {
+	File      string    `json:"file"`      // Name of the screenshot file, within the screenshots directory
+	Timestamp time.Time `json:"timestamp"` // Time at which the screenshot was taken
+	FullPage  bool      `json:"full_page"` // Whether this is a full-page screenshot (as opposed to just the viewport)
+}
+
+// ScreenshotSeries reports whether screenshots should be stored in the screenshots directory with an index.
+// Tasks using the default screenshot settings get a single screenshot.png, as they always have.
+func ScreenshotSeries(ds *DataSettings) bool {
+	return *ds.ScreenshotFullPage || *ds.ScreenshotInterval != 0 || *ds.ScreenshotFormat != "png"
+}
+
+// A single frame of a screencast, as stored in the screencast index
+type DTScreencastFrame struct {
+	File     string                        `json:"file"`     // Name of the frame file, within the screencast directory
+	Metadata *page.ScreencastFrameMetadata `json:"metadata"` // Frame metadata (including timestamp) from the browser
+}
+
+// The web storage belonging to a single origin
+type DTOriginStorage struct {
+	LocalStorage          map[string]string `json:"local_storage"`
+	LocalStorageTruncated bool              `json:"local_storage_truncated,omitempty"` // True if items were dropped because of the item cap
+
+	SessionStorage          map[string]string `json:"session_storage"`
+	SessionStorageTruncated bool              `json:"session_storage_truncated,omitempty"` // True if items were dropped because of the item cap
+
+	IndexedDB []*DTIndexedDBDatabase `json:"indexeddb"`
+}
+
+// An IndexedDB database
+type DTIndexedDBDatabase struct {
+	Name         string                    `json:"name"`
+	Version      float64                   `json:"version"`
+	ObjectStores []*DTIndexedDBObjectStore `json:"object_stores"`
+}
+
+// An IndexedDB object store, along with (up to a cap) the entries it holds
+type DTIndexedDBObjectStore struct {
+	Name          string              `json:"name"`
+	KeyPath       *indexeddb.KeyPath  `json:"key_path,omitempty"`
+	AutoIncrement bool                `json:"auto_increment"`
+	NumEntries    int                 `json:"num_entries"`         // Number of entries in the object store (including any beyond the cap)
+	Entries       []*DTIndexedDBEntry `json:"entries"`             // Entries we gathered from the object store
+	Truncated     bool                `json:"truncated,omitempty"` // True if entries were dropped because of the entry cap
+}
+
+// A single entry of an IndexedDB object store. Objects are given by their descriptions
+// rather than their full contents.
+type DTIndexedDBEntry struct {
+	Key        string `json:"key"`
+	PrimaryKey string `json:"primary_key"`
+	Value      string `json:"value"`
+}
+
+// A file downloaded by the page
+type DTDownload struct {
+	GUID              string    `json:"guid"`                // Identifier of the download, which is also the name of the stored file
+	URL               string    `json:"url"`                 // URL of the downloaded resource
+	SuggestedFilename string    `json:"suggested_filename"`  // File name suggested for the download (by the server or page)
+	FrameID           string    `json:"frame_id"`            // Frame which caused the download to begin
+	Began             time.Time `json:"began"`               // Time at which the download began
+	State             string    `json:"state"`               // Final state of the download (inProgress, completed or canceled)
+	TotalBytes        float64   `json:"total_bytes"`         // Expected size of the download, if known
+	ReceivedBytes     float64   `json:"received_bytes"`      // Bytes received before the download finished (or was canceled)
+	ExceededSizeCap   bool      `json:"exceeded_size_cap"`   // True if we canceled the download because it was too large
+	SHA256            string    `json:"sha256,omitempty"`    // Hash of the stored file, for completed downloads
+	MIMEType          string    `json:"mime_type,omitempty"` // MIME type of the stored file (sniffed from its contents)
+}
+
+// A popup opened during the site visit, which we kept open and recorded as a child result of the task
+type DTPopup struct {
+	Index         int                    `json:"index"`           // Results for the popup are stored in popups/<index>
+	TargetID      string                 `json:"target_id"`       // DevTools target ID of the popup
+	OpenerID      string                 `json:"opener_id"`       // Target ID of the page which opened the popup
+	OpenerFrameID string                 `json:"opener_frame_id"` // Frame which opened the popup
+	InitialURL    string                 `json:"initial_url"`     // URL of the popup when it was opened
+	Opened        time.Time              `json:"opened"`          // Time at which the popup was opened
+	NavHistory    []page.NavigationEntry `json:"nav_history"`     // Navigation history of the popup at the end of the visit
+	NumResources  int                    `json:"num_resources"`   // Number of resources the popup loaded
+
+	ResourceMetadata map[string]DTResource `json:"-"` // Stored in the popup's own results directory
+	Screenshot       string                `json:"-"` // Path to the popup's screenshot within the temp directory, if any
+}
+
+// A frame within the page, including any frames which were later detached
+type DTFrame struct {
+	ID           string   `json:"id"`
+	ParentID     string   `json:"parent_id,omitempty"` // Empty for the main frame
+	Name         string   `json:"name,omitempty"`
+	URL          string   `json:"url"`            // Last URL the frame navigated to
+	Origin       string   `json:"origin"`         // Security origin of the frame's last document
+	URLHistory   []string `json:"url_history"`    // Every URL the frame navigated to, in order
+	CrossOrigin  bool     `json:"cross_origin"`   // True if the frame's origin differs from its parent's
+	OutOfProcess bool     `json:"out_of_process"` // True if the frame ran in its own renderer process (and target)
+	Detached     bool     `json:"detached"`       // True if the frame was removed from the page
+	RequestIDs   []string `json:"request_ids"`    // Requests made by the frame (keys of resource_metadata.json)
+}
+
+// A DOM snapshot, with one entry per document (the page itself, plus any frames)
+type DTDOMSnapshot struct {
+	Documents []*DTSnapshotDocument `json:"documents"`
+}
+
+// A document within a DOM snapshot
+type DTSnapshotDocument struct {
+	URL           string            `json:"url"`
+	Title         string            `json:"title"`
+	FrameID       string            `json:"frame_id"`
+	ContentWidth  float64           `json:"content_width"`
+	ContentHeight float64           `json:"content_height"`
+	Nodes         []*DTSnapshotNode `json:"nodes"` // Element nodes in the document
+}
+
+// An element within a DOM snapshot, along with its layout
+type DTSnapshotNode struct {
+	NodeIndex       int64             `json:"node_index"`                 // Index of the node within the document's snapshot
+	ParentIndex     int64             `json:"parent_index"`               // Index of the node's parent (-1 for the root)
+	BackendNodeID   cdp.BackendNodeID `json:"backend_node_id"`            // Backend ID of the node
+	NodeName        string            `json:"node_name"`                  // Name of the node (e.g., "IFRAME")
+	Attributes      map[string]string `json:"attributes,omitempty"`       // Attributes of the node
+	ContentDocument *int64            `json:"content_document,omitempty"` // For frame owners, index of the frame's document
+	Bounds          []float64         `json:"bounds,omitempty"`           // Absolute bounding box (x, y, width, height), if the node was laid out
+	PaintOrder      int64             `json:"paint_order,omitempty"`      // Global paint order of the node, if it was laid out
+	Styles          map[string]string `json:"styles,omitempty"`           // Requested computed styles, if the node was laid out
+	Visible         bool              `json:"visible"`                    // Whether the node was laid out with a non-empty box and is not hidden by its styles
+}
+
+// A filter list rule which matched a request
+type DTFilterMatch struct {
+	Rule      string `json:"rule"`                // The matching filter, as it appears in the list
+	List      string `json:"list"`                // Name of the list containing the filter
+	Exception string `json:"exception,omitempty"` // Exception filter which allowed the request anyway, if any
+	Blocked   bool   `json:"blocked"`             // Whether MIDA blocked the request
+}
+
+// A request which matched one of the task's request rules
+type DTRequestRuleHit struct {
+	Rule         string               `json:"rule"`            // Name of the rule which matched
+	RequestID    string               `json:"request_id"`      // Network ID of the matching request
+	URL          string               `json:"url"`             // URL of the matching request
+	ResourceType network.ResourceType `json:"resource_type"`   // Resource type of the matching request
+	Action       RequestRuleAction    `json:"action"`          // Action taken on the request
+	Timestamp    time.Time            `json:"timestamp"`       // Time at which the rule was applied
+	Error        string               `json:"error,omitempty"` // Why we failed to apply the rule, if we did
+}
+
+// An event listener found on the page, along with the results of MIDA triggering it
+type DTEventListener struct {
+	Target        string            `json:"target"`                    // "window", or the name of the DOM node the listener is attached to
+	BackendNodeID cdp.BackendNodeID `json:"backend_node_id,omitempty"` // Backend ID of the DOM node the listener is attached to (if any)
+	Type          string            `json:"type"`                      // Type of event the listener handles (e.g., "click")
+	ScriptID      runtime.ScriptID  `json:"script_id"`                 // Script containing the handler code
+	LineNumber    int64             `json:"line_number"`               // Line number of the handler in the script (0-based)
+	ColumnNumber  int64             `json:"column_number"`             // Column number of the handler in the script (0-based)
+	Dispatched    bool              `json:"dispatched"`                // Whether MIDA dispatched an event to this listener's target (not whether the handler ran)
+	Error         string            `json:"error,omitempty"`           // Why we failed to dispatch the event, if we did
+	Requests      []string          `json:"requests"`                  // IDs of the network requests sent after the event was dispatched
+}
+
+// A record reported by an init script through the instrumentation binding (see DefaultInstrumentationBinding)
+type DTInstrumentationRecord struct {
+	Timestamp          time.Time       `json:"timestamp"`            // When we received the record
+	ExecutionContextID int64           `json:"execution_context_id"` // JavaScript context the record was reported from
+	Record             json.RawMessage `json:"record"`               // As reported (wrapped in a string if it was not valid JSON)
+}
+
+// A cookie gathered from the browser, marked if it is one we seeded before navigation (and the page left it alone)
+type DTCookie struct {
+	*network.Cookie
+	Seeded bool `json:"seeded"`
+}
+
+// MarshalJSON writes out the cookie as the browser reports it, with the seeded flag alongside its fields. Without it,
+// the JSON methods of the embedded cookie would be used as they are, and the flag would be lost.
+func (c DTCookie) MarshalJSON() ([]byte, error) {
+	return marshalWithFields(c.Cookie, map[string]interface{}{"seeded": c.Seeded})
+}
+
+// marshalWithFields marshals an embedded DevTools type, which has JSON methods of its own,
+// and adds extra fields alongside its own
+func marshalWithFields(embedded interface{}, extra map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(embedded)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		fields[k], err = json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(fields)
 }
 
 type FinalResult struct {
-	Summary            TaskSummary                            `json:"stats"`   // Statistics on timing and resource usage for the crawl
-	DTCookies          []*network.Cookie                      `json:"cookies"` // Cookies collected from DevTools protocol
-	DTDOM              *cdp.Node                              `json:"dom"`
-	DTResourceMetadata map[string]DTResource                  `json:"resource_metadata"` // Metadata on each resource loaded
-	DTScriptMetadata   map[string]*debugger.EventScriptParsed `json:"script_metadata"`   // Metadata on each script parsed
+	Summary            TaskSummary             `json:"stats"`   // Statistics on timing and resource usage for the crawl
+	DTCookies          []*DTCookie             `json:"cookies"` // Cookies collected from DevTools protocol
+	DTDOM              *cdp.Node               `json:"dom"`
+	DTResourceMetadata map[string]DTResource   `json:"resource_metadata"` // Metadata on each resource loaded
+	DTScriptMetadata   map[string]*DTScript    `json:"script_metadata"`   // Metadata (and coverage) on each script parsed
+	DTWebSockets       map[string]*DTWebSocket `json:"websockets"`        // Websockets opened by the page, keyed by request ID
+	DTEventListeners   []*DTEventListener      `json:"event_listeners"`   // Event listeners triggered after load
+	DTConsole          *DTConsole              `json:"console"`           // Console output from the page
+	DTRequestRuleHits  []*DTRequestRuleHit     `json:"request_rule_hits"` // Requests which matched a request rule
+
+	DTEventSourceMessages map[string][]*network.EventEventSourceMessageReceived `json:"event_source_messages"` // Server-Sent Events messages, keyed by request ID
+	HAR                   *har.HAR                                              `json:"har,omitempty"`         // HAR log built from network data
+	MHTML                 string                                                `json:"-"`                     // MHTML snapshot of the page, stored as its own file
+	DTDownloads           []*DTDownload                                         `json:"downloads"`             // Files downloaded by the page
+	DTPopups              []*DTPopup                                            `json:"popups"`                // Popups opened by the page, if we followed them
+	DTFrames              map[string]*DTFrame                                   `json:"frames"`                // Frame tree, keyed by frame ID
+	DTWebStorage          map[string]*DTOriginStorage                           `json:"web_storage"`           // Web storage contents, keyed by origin
+	DTDOMSnapshot         *DTDOMSnapshot                                        `json:"dom_snapshot"`          // DOM snapshot with layout information
+	DTInstrumentation     []*DTInstrumentationRecord                            `json:"instrumentation"`       // Records reported by init scripts
 }
 
 func AllocateNewCompressedTaskSet() *CompressedTaskSet {
@@ -265,11 +876,15 @@ func AllocateNewInteractionSettings() *InteractionSettings {
 	is.BasicInteraction = new(bool)
 	is.TriggerEventListeners = new(bool)
 	is.Gremlins = new(bool)
+	is.FollowPopups = new(bool)
+	is.MaxPopups = new(int)
 
 	*is.LockNavigation = DefaultNavLockAfterLoad
 	*is.BasicInteraction = DefaultBasicInteraction
 	*is.Gremlins = DefaultGremlins
 	*is.TriggerEventListeners = DefaultTriggerEventListeners
+	*is.FollowPopups = DefaultFollowPopups
+	*is.MaxPopups = DefaultMaxPopups
 
 	return is
 }
@@ -308,6 +923,24 @@ func AllocateNewDataSettings() *DataSettings {
 	ds.ResourceMetadata = new(bool)
 	ds.Screenshot = new(bool)
 	ds.ScriptMetadata = new(bool)
+	ds.WebSocket = new(bool)
+	ds.EventSourceMessages = new(bool)
+	ds.HAR = new(bool)
+	ds.WARC = new(bool)
+	ds.Console = new(bool)
+	ds.JSCoverage = new(bool)
+	ds.Screencast = new(bool)
+	ds.ScreencastGIF = new(bool)
+	ds.MHTML = new(bool)
+	ds.DOMSnapshot = new(bool)
+	ds.DOMSnapshotStyles = new([]string)
+	ds.WebStorage = new(bool)
+	ds.Frames = new(bool)
+	ds.Downloads = new(bool)
+	ds.ScreenshotFullPage = new(bool)
+	ds.ScreenshotInterval = new(int)
+	ds.ScreenshotFormat = new(string)
+	ds.ScreenshotQuality = new(int)
 	ds.BrowserCoverage = new(bool)
 	ds.RawCovFiles = new(bool)
 	ds.CovTxtFile = new(bool)
@@ -417,6 +1050,16 @@ func ExpandCompressedTaskSet(ts CompressedTaskSet) []RawTask {
 				Completion: ts.Completion,
 				Data:       ts.Data,
 				Output:     ts.Output,
+
+				RequestRules: ts.RequestRules,
+				Replay:       ts.Replay,
+
+				NetworkConditions: ts.NetworkConditions,
+
+				SeedCookies:      ts.SeedCookies,
+				SeedLocalStorage: ts.SeedLocalStorage,
+
+				PostLoadScripts: ts.PostLoadScripts,
 			}
 			rawTasks = append(rawTasks, newTask)
 		}
@@ -8,6 +8,21 @@ const (
 	DefaultScriptSubdir           = "scripts"
 	DefaultCoverageSubdir         = "coverage"
 	DefaultScreenshotFileName     = "screenshot.png"
+	DefaultScreenshotSubdir       = "screenshots"
+	DefaultScreenshotIndexFile    = "index.json"
+	DefaultScreencastSubdir       = "screencast"
+	DefaultScreencastIndexFile    = "index.json"
+	DefaultScreencastGIFFile      = "screencast.gif"
+	DefaultMHTMLFile              = "page.mhtml"
+	DefaultDOMSnapshotFile        = "dom_snapshot.json"
+	DefaultWebStorageFile         = "storage.json"
+	DefaultFramesFile             = "frames.json"
+	DefaultPopupSubdir            = "popups"
+	DefaultPopupIndexFile         = "index.json"
+	DefaultDownloadSubdir         = "downloads"
+	DefaultDownloadIndexFile      = "index.json"
+	DefaultInstrumentationFile    = "instrumentation.jsonl"
+	DefaultScriptResultsFile      = "script_results.json"
 	DefaultCookieFileName         = "cookies.json"
 	DefaultDomFileName            = "dom.json"
 	DefaultMetadataFile           = "metadata.json"
@@ -15,6 +30,13 @@ const (
 	DefaultCovTreeSummaryFileName = "cov_tree.csv"
 	DefaultResourceMetadataFile   = "resource_metadata.json"
 	DefaultScriptMetadataFile     = "script_metadata.json"
+	DefaultWebSocketFile          = "websockets.json"
+	DefaultEventSourceFile        = "event_source_messages.json"
+	DefaultEventListenerFile      = "event_listeners.json"
+	DefaultHARFile                = "crawl.har"
+	DefaultWARCFile               = "crawl.warc.gz"
+	DefaultConsoleFile            = "console.json"
+	DefaultRequestRuleHitsFile    = "request_rule_hits.json"
 	DefaultSftpPrivKeyFile        = "~/.ssh/id_rsa"
 	DefaultTaskLogFile            = "task.log"
 
@@ -45,6 +67,13 @@ const (
 	DefaultBasicInteraction      = false
 	DefaultGremlins              = false
 	DefaultTriggerEventListeners = false
+	DefaultFollowPopups          = false
+	DefaultMaxPopups             = 5
+
+	DefaultEventListenerSettleTime = 250 // Time (in milliseconds) to wait for network requests after triggering an event listener
+
+	// Request rules
+	DefaultFulfillStatus = 200 // HTTP status code used when fulfilling a request from a local file
 
 	// Defaults for data gathering settings
 	DefaultAllResources     = true
@@ -54,10 +83,42 @@ const (
 	DefaultResourceMetadata = true
 	DefaultScreenshot       = true
 	DefaultScriptMetadata   = false
-	DefaultBrowserCoverage  = false
-	DefaultRawCovFiles      = false
-	DefaultCovTxtFile       = false
-	DefaultCovTreeSummary   = false
+	DefaultWebSocket        = false
+	DefaultEventSource      = false
+	DefaultHAR              = false
+	DefaultWARC             = false
+	DefaultConsole          = false
+	DefaultJSCoverage       = false
+	DefaultScreencast       = false
+	DefaultScreencastGIF    = false
+	DefaultMHTML            = false
+	DefaultDOMSnapshot      = false
+	DefaultWebStorage       = false
+	DefaultFrames           = false
+	DefaultDownloads        = false
+
+	DefaultScreenshotFullPage = false
+	DefaultScreenshotInterval = 0 // Seconds between screenshots (0 means we only take one, after load)
+	DefaultScreenshotFormat   = "png"
+	DefaultScreenshotQuality  = 80
+
+	DefaultScreencastQuality      = 60  // JPEG quality of screencast frames
+	DefaultScreencastGIFMaxFrames = 300 // Frames beyond this are dropped (evenly) when assembling a GIF
+
+	DefaultWebStorageMaxItems       = 1000 // Maximum items we store from a single localStorage/sessionStorage area
+	DefaultIndexedDBMaxEntries      = 1000 // Maximum entries we store from a single IndexedDB object store
+	DefaultWebStorageMaxValueLength = 8192 // Stored values (and IndexedDB keys) longer than this are truncated
+
+	DefaultMaxDownloadSize = 100 * 1024 * 1024 // Downloads larger than this (in bytes) are canceled
+
+	// Init scripts report records by calling this function with a string (usually JSON.stringify-ed)
+	DefaultInstrumentationBinding    = "__midaReport"
+	DefaultMaxInstrumentationRecords = 100000 // Records beyond this are dropped
+
+	DefaultBrowserCoverage = false
+	DefaultRawCovFiles     = false
+	DefaultCovTxtFile      = false
+	DefaultCovTreeSummary  = false
 
 	DefaultShuffle = true // Whether to shuffle order of task processing
 
@@ -66,6 +127,76 @@ const (
 
 var (
 
+	// Device profiles which can be selected by name in emulation settings
+	DefaultDeviceProfiles = map[string]SanitizedEmulation{
+		"desktop": {
+			Width:             1920,
+			Height:            1080,
+			DeviceScaleFactor: 1,
+			UserAgent:         "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			ClientHints: &ClientHints{
+				Brands:          chromiumBrands,
+				Platform:        "Windows",
+				PlatformVersion: "10.0.0",
+				Architecture:    "x86",
+			},
+		},
+		"laptop": {
+			Width:             1440,
+			Height:            900,
+			DeviceScaleFactor: 2,
+			UserAgent:         "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			ClientHints: &ClientHints{
+				Brands:          chromiumBrands,
+				Platform:        "macOS",
+				PlatformVersion: "14.2.0",
+				Architecture:    "arm",
+			},
+		},
+		"pixel_7": {
+			Width:             412,
+			Height:            915,
+			DeviceScaleFactor: 2.625,
+			Mobile:            true,
+			Touch:             true,
+			UserAgent:         "Mozilla/5.0 (Linux; Android 14; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			ClientHints: &ClientHints{
+				Brands:          chromiumBrands,
+				Platform:        "Android",
+				PlatformVersion: "14.0.0",
+				Model:           "Pixel 7",
+			},
+		},
+		"iphone_14": {
+			Width:             390,
+			Height:            844,
+			DeviceScaleFactor: 3,
+			Mobile:            true,
+			Touch:             true,
+			UserAgent:         "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+		},
+		"ipad": {
+			Width:             820,
+			Height:            1180,
+			DeviceScaleFactor: 2,
+			Mobile:            true,
+			Touch:             true,
+			UserAgent:         "Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+		},
+	}
+
+	// Network profiles which can be selected by name, based on those offered by Chrome DevTools
+	DefaultNetworkProfiles = map[string]SanitizedNetworkConditions{
+		"offline": {Offline: true, CPUThrottlingRate: 1},
+		"slow_3g": {Latency: 2000, DownloadThroughput: 400, UploadThroughput: 400, CPUThrottlingRate: 1},
+		"3g":      {Latency: 562.5, DownloadThroughput: 1440, UploadThroughput: 675, CPUThrottlingRate: 1},
+		"4g":      {Latency: 20, DownloadThroughput: 4000, UploadThroughput: 3000, CPUThrottlingRate: 1},
+	}
+
+	// Computed styles we include in DOM snapshots by default. These are the ones we need to judge visibility,
+	// so they are included even when the task asks for other styles.
+	DefaultDOMSnapshotStyles = []string{"display", "visibility", "opacity", "position", "z-index", "overflow"}
+
 	// Flags we apply by default to Chrome/Chromium-based browsers
 	DefaultChromiumBrowserFlags = []string{
 		"--enable-features=NetworkService",
@@ -91,3 +222,10 @@ var (
 		"--safebrowsing-disable-auto-update",
 	}
 )
+
+// Brands sent as client hints by the Chromium-based device profiles. Safari does not send client hints.
+var chromiumBrands = []ClientHintBrand{
+	{Brand: "Not_A Brand", Version: "8"},
+	{Brand: "Chromium", Version: "120"},
+	{Brand: "Google Chrome", Version: "120"},
+}
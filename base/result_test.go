@@ -0,0 +1,44 @@
+package base
+
+import (
+	"encoding/json"
+	"github.com/chromedp/cdproto/debugger"
+	"testing"
+)
+
+// TestDTScriptMarshal checks that script coverage is written out alongside the fields of the parsed script
+func TestDTScriptMarshal(t *testing.T) {
+	t.Parallel()
+
+	script := DTScript{
+		EventScriptParsed: &debugger.EventScriptParsed{ScriptID: "42", URL: "https://example.com/a.js"},
+		Coverage:          &DTScriptCoverage{TotalBytes: 10, UsedBytes: 5, PercentUsed: 50},
+	}
+	data, err := json.Marshal(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := make(map[string]json.RawMessage)
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["coverage"]; !ok {
+		t.Fatalf("coverage missing from marshaled script: %s", data)
+	}
+	if string(fields["scriptId"]) != `"42"` {
+		t.Fatalf("script fields missing from marshaled script: %s", data)
+	}
+
+	script.Coverage = nil
+	data, err = json.Marshal(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields = make(map[string]json.RawMessage)
+	_ = json.Unmarshal(data, &fields)
+	if _, ok := fields["coverage"]; ok {
+		t.Fatalf("coverage present for script without coverage: %s", data)
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"errors"
 	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/debugger"
 	"github.com/chromedp/cdproto/fetch"
+	cdplog "github.com/chromedp/cdproto/log"
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/profiler"
+	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/cdproto/target"
 	"github.com/teamnsrg/chromedp"
+	"github.com/teamnsrg/mida/adblock"
 	b "github.com/teamnsrg/mida/base"
 	"github.com/teamnsrg/mida/log"
 	"os"
@@ -41,12 +46,33 @@ type EventChannels struct {
 	webSocketHandshakeResponseReceivedChan chan *network.EventWebSocketHandshakeResponseReceived
 	EventSourceMessageReceivedChan         chan *network.EventEventSourceMessageReceived
 	requestPausedChan                      chan *fetch.EventRequestPaused
+	authRequiredChan                       chan *fetch.EventAuthRequired
 	scriptParsedChan                       chan *debugger.EventScriptParsed
 	targetCreatedChan                      chan *target.EventTargetCreated
+	attachedToTargetChan                   chan *target.EventAttachedToTarget
+	consoleAPICalledChan                   chan *runtime.EventConsoleAPICalled
+	exceptionThrownChan                    chan *runtime.EventExceptionThrown
+	bindingCalledChan                      chan *runtime.EventBindingCalled
+	logEntryAddedChan                      chan *cdplog.EventEntryAdded
+	screencastFrameChan                    chan *page.EventScreencastFrame
+	frameAttachedChan                      chan *page.EventFrameAttached
+	frameDetachedChan                      chan *page.EventFrameDetached
+	targetInfoChangedChan                  chan *target.EventTargetInfoChanged
+	downloadWillBeginChan                  chan *browser.EventDownloadWillBegin
+	downloadProgressChan                   chan *browser.EventDownloadProgress
 }
 
 type DTState struct {
 	mainFrameLoaderId string
+	filters           *adblock.Engine // Filter lists for the task, which are read-only once loaded
+	replay            *replayArchive  // Recorded responses, if we are replaying an earlier crawl
+
+	seedScript page.ScriptIdentifier // Script which seeds localStorage into new documents, until the page loads
+
+	numPopups     int            // Number of popups we have started following
+	popupsStopped bool           // Set once the visit is ending, after which we follow no new popups
+	popupStop     chan bool      // Closed to signal popups to finish up
+	popupWG       sync.WaitGroup // Used to wait for popups to finish
 	sync.Mutex
 }
 
@@ -69,8 +95,23 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 			Network: b.DevToolsNetworkRawData{
 				RequestWillBeSent: make(map[string][]*network.EventRequestWillBeSent),
 				ResponseReceived:  make(map[string]*network.EventResponseReceived),
+				LoadingFinished:   make(map[string]*network.EventLoadingFinished),
+
+				EventSourceMessageReceived: make(map[string][]*network.EventEventSourceMessageReceived),
+
+				FilterMatches: make(map[string]*b.DTFilterMatch),
+				FilterBlocked: make(map[string]bool),
+			},
+			Scripts:   make(b.DevToolsScriptRawData, 0),
+			Downloads: make(map[string]*b.DTDownload),
+			WebSockets: b.DevToolsWebSocketRawData{
+				Created:           make(map[string]*network.EventWebSocketCreated),
+				HandshakeRequest:  make(map[string]*network.EventWebSocketWillSendHandshakeRequest),
+				HandshakeResponse: make(map[string]*network.EventWebSocketHandshakeResponseReceived),
+				Frames:            make(map[string][]*b.DTWebSocketFrame),
+				FrameErrors:       make(map[string][]*network.EventWebSocketFrameError),
+				Closed:            make(map[string]*network.EventWebSocketClosed),
 			},
-			Scripts: make(b.DevToolsScriptRawData, 0),
 		},
 	}
 
@@ -81,6 +122,31 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 
 	// DevTools-specific state we need to use across various goroutines
 	var devToolsState DTState
+	devToolsState.popupStop = make(chan bool)
+
+	// Load filter lists (if any), so we can label requests as they are sent
+	if len(tw.SanitizedTask.FilterLists) > 0 {
+		devToolsState.filters, err = adblock.Load(tw.SanitizedTask.FilterLists)
+		if err != nil {
+			tw.Log.Error("failed to load filter lists: ", err)
+			return nil, err
+		}
+	}
+
+	rawResult.TaskSummary.Proxy = tw.SanitizedTask.Proxy
+
+	// Load the recorded responses we will replay (if any)
+	if tw.SanitizedTask.ReplayDirectory != "" {
+		devToolsState.replay, err = loadReplayArchive(tw.SanitizedTask.ReplayDirectory)
+		if err != nil {
+			tw.Log.Error("failed to load results to replay: ", err)
+			return nil, err
+		}
+		rawResult.TaskSummary.ReplayData = &b.ReplayMetadata{
+			Directory: tw.SanitizedTask.ReplayDirectory,
+			Misses:    make([]string, 0),
+		}
+	}
 
 	// Make sure user data directory exists already. If not, create it.
 	// If we can't create it, we consider it a bad enough error that we
@@ -93,8 +159,8 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 		}
 	}
 
-	// If we are gathering all the resources, we need to create the corresponding directory
-	if *(tw.SanitizedTask.DS.AllResources) {
+	// If we are gathering all the resources (or need their bodies for a WARC), we need to create the corresponding directory
+	if *(tw.SanitizedTask.DS.AllResources) || *(tw.SanitizedTask.DS.WARC) {
 		// Create a subdirectory where we will store all the files
 		_, err = os.Stat(path.Join(tw.TempDir, b.DefaultResourceSubdir))
 		if err != nil {
@@ -118,6 +184,30 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 		}
 	}
 
+	// Screencast frames are written out as they arrive, so they need a directory as well
+	if *(tw.SanitizedTask.DS.Screencast) {
+		_, err = os.Stat(path.Join(tw.TempDir, b.DefaultScreencastSubdir))
+		if err != nil {
+			err = os.MkdirAll(path.Join(tw.TempDir, b.DefaultScreencastSubdir), 0744)
+			if err != nil {
+				tw.Log.Error("failed to create screencast subdir within temp directory")
+				return nil, err
+			}
+		}
+	}
+
+	// The browser saves downloads straight into the temp directory
+	if *(tw.SanitizedTask.DS.Downloads) {
+		_, err = os.Stat(path.Join(tw.TempDir, b.DefaultDownloadSubdir))
+		if err != nil {
+			err = os.MkdirAll(path.Join(tw.TempDir, b.DefaultDownloadSubdir), 0744)
+			if err != nil {
+				tw.Log.Error("failed to create download subdir within temp directory")
+				return nil, err
+			}
+		}
+	}
+
 	// Build our opts slice
 	var opts []chromedp.ExecAllocatorOption
 	for _, flagString := range tw.SanitizedTask.BrowserFlags {
@@ -168,16 +258,27 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 	browserContext, _ := chromedp.NewContext(allocContext)
 
 	// Get our event listener goroutines up and running
-	eventHandlerWG.Add(9) // *** UPDATE ME WHEN YOU ADD A NEW EVENT HANDLER ***
+	eventHandlerWG.Add(20) // *** UPDATE ME WHEN YOU ADD A NEW EVENT HANDLER ***
 	go FetchRequestPaused(ec.requestPausedChan, &rawResult, &devToolsState, &eventHandlerWG, browserContext)
-	go PageFrameNavigated(ec.frameNavigatedChan, &devToolsState, &eventHandlerWG, browserContext)
+	go FetchAuthRequired(ec.authRequiredChan, &rawResult, &eventHandlerWG, browserContext)
+	go PageFrameNavigated(ec.frameNavigatedChan, &rawResult, &devToolsState, &eventHandlerWG, browserContext)
+	go PageFrames(&ec, &rawResult, &eventHandlerWG, browserContext)
+	go BrowserDownload(&ec, &rawResult, &eventHandlerWG, browserContext)
 	go PageLoadEventFired(ec.loadEventFiredChan, loadEventChan, &rawResult, &eventHandlerWG, browserContext)
 	go PageJavaScriptDialogOpening(ec.javascriptDialogOpeningChan, &eventHandlerWG, browserContext, tw.Log)
 	go NetworkLoadingFinished(ec.loadingFinishedChan, &rawResult, &eventHandlerWG, browserContext, tw.Log)
-	go NetworkRequestWillBeSent(ec.requestWillBeSentChan, &rawResult, &eventHandlerWG, browserContext)
+	go NetworkRequestWillBeSent(ec.requestWillBeSentChan, &rawResult, &devToolsState, &eventHandlerWG, browserContext)
 	go NetworkResponseReceived(ec.responseReceivedChan, &rawResult, &eventHandlerWG, browserContext)
-	go TargetTargetCreated(ec.targetCreatedChan, &eventHandlerWG, browserContext, tw.SanitizedTask.URL)
+	go TargetTargetCreated(ec.targetCreatedChan, &rawResult, &devToolsState, &eventHandlerWG, browserContext)
+	go TargetAttachedToTarget(ec.attachedToTargetChan, &rawResult, &devToolsState, &eventHandlerWG, browserContext)
 	go DebuggerScriptParsed(ec.scriptParsedChan, &rawResult, &eventHandlerWG, browserContext)
+	go NetworkWebSocket(&ec, &rawResult, &eventHandlerWG, browserContext)
+	go NetworkEventSourceMessageReceived(ec.EventSourceMessageReceivedChan, &rawResult, &eventHandlerWG, browserContext)
+	go RuntimeConsoleAPICalled(ec.consoleAPICalledChan, &rawResult, &eventHandlerWG, browserContext)
+	go RuntimeExceptionThrown(ec.exceptionThrownChan, &rawResult, &eventHandlerWG, browserContext)
+	go RuntimeBindingCalled(ec.bindingCalledChan, &rawResult, &eventHandlerWG, browserContext)
+	go LogEntryAdded(ec.logEntryAddedChan, &rawResult, &eventHandlerWG, browserContext)
+	go PageScreencastFrame(ec.screencastFrameChan, &rawResult, &eventHandlerWG, browserContext)
 
 	// The browser will open now, when we run our first chromedp ActionFunc
 	rawResult.Lock()
@@ -202,18 +303,68 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 			return err
 		}
 
+		// Runtime is only needed for console output and for init scripts to report back to us, and Log is only
+		// needed for console output. chromedp enables both on every target regardless, so the event handlers
+		// decide what we actually collect.
+		if *tw.SanitizedTask.DS.Console || len(tw.SanitizedTask.InitScripts) > 0 {
+			err = runtime.Enable().Do(cxt)
+			if err != nil {
+				return err
+			}
+		}
+		if *tw.SanitizedTask.DS.Console {
+			err = cdplog.Enable().Do(cxt)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Downloads are named by their GUIDs, so they cannot collide or escape the directory
+		if *tw.SanitizedTask.DS.Downloads {
+			err = browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllowAndName).
+				WithDownloadPath(path.Join(tw.TempDir, b.DefaultDownloadSubdir)).WithEventsEnabled(true).Do(cxt)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Start coverage before navigation so we see scripts which only run during page load
+		if *tw.SanitizedTask.DS.JSCoverage {
+			err = profiler.Enable().Do(cxt)
+			if err != nil {
+				return err
+			}
+
+			_, err = profiler.StartPreciseCoverage().WithCallCount(true).WithDetailed(true).Do(cxt)
+			if err != nil {
+				return err
+			}
+		}
+
 		_, product, revision, userAgent, jsVersion, err := browser.GetVersion().Do(cxt)
 		if err != nil {
 			return err
 		}
 
+		userAgent, err = prepareTarget(cxt, tw, &devToolsState, userAgent)
+		if err != nil {
+			return err
+		}
+
 		rawResult.Lock()
 		rawResult.TaskSummary.CrawlerInfo.Browser = product
 		rawResult.TaskSummary.CrawlerInfo.BrowserVersion = revision
 		rawResult.TaskSummary.CrawlerInfo.UserAgent = userAgent
 		rawResult.TaskSummary.CrawlerInfo.JSVersion = jsVersion
+		rawResult.TaskSummary.CrawlerInfo.Emulation = tw.SanitizedTask.Emulation
+		rawResult.TaskSummary.NetworkConditions = tw.SanitizedTask.NetworkConditions
 		rawResult.Unlock()
 
+		err = seedStorage(cxt, &tw.SanitizedTask, &devToolsState)
+		if err != nil {
+			return err
+		}
+
 		return nil
 	}))
 	if err != nil {
@@ -241,6 +392,10 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 			ec.loadEventFiredChan <- ev.(*page.EventLoadEventFired)
 		case *page.EventFrameNavigated:
 			ec.frameNavigatedChan <- ev.(*page.EventFrameNavigated)
+		case *page.EventFrameAttached:
+			ec.frameAttachedChan <- ev.(*page.EventFrameAttached)
+		case *page.EventFrameDetached:
+			ec.frameDetachedChan <- ev.(*page.EventFrameDetached)
 		case *page.EventFrameRequestedNavigation:
 			ec.frameRequestedNavigationChan <- ev.(*page.EventFrameRequestedNavigation)
 		case *page.EventJavascriptDialogOpening:
@@ -252,18 +407,88 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 			ec.responseReceivedChan <- ev.(*network.EventResponseReceived)
 		case *network.EventLoadingFinished:
 			ec.loadingFinishedChan <- ev.(*network.EventLoadingFinished)
+		case *network.EventWebSocketCreated:
+			ec.webSocketCreatedChan <- ev.(*network.EventWebSocketCreated)
+		case *network.EventWebSocketWillSendHandshakeRequest:
+			ec.webSocketWillSendHandshakeRequestChan <- ev.(*network.EventWebSocketWillSendHandshakeRequest)
+		case *network.EventWebSocketHandshakeResponseReceived:
+			ec.webSocketHandshakeResponseReceivedChan <- ev.(*network.EventWebSocketHandshakeResponseReceived)
+		case *network.EventWebSocketFrameSent:
+			ec.webSocketFrameSentChan <- ev.(*network.EventWebSocketFrameSent)
+		case *network.EventWebSocketFrameReceived:
+			ec.webSocketFrameReceivedChan <- ev.(*network.EventWebSocketFrameReceived)
+		case *network.EventWebSocketFrameError:
+			ec.webSocketFrameErrorChan <- ev.(*network.EventWebSocketFrameError)
+		case *network.EventWebSocketClosed:
+			ec.webSocketClosedChan <- ev.(*network.EventWebSocketClosed)
+		case *network.EventEventSourceMessageReceived:
+			ec.EventSourceMessageReceivedChan <- ev.(*network.EventEventSourceMessageReceived)
 
 		case *fetch.EventRequestPaused:
 			ec.requestPausedChan <- ev.(*fetch.EventRequestPaused)
+		case *fetch.EventAuthRequired:
+			ec.authRequiredChan <- ev.(*fetch.EventAuthRequired)
 
 		case *target.EventTargetCreated:
 			ec.targetCreatedChan <- ev.(*target.EventTargetCreated)
+		case *target.EventTargetInfoChanged:
+			ec.targetInfoChangedChan <- ev.(*target.EventTargetInfoChanged)
+
+		case *page.EventScreencastFrame:
+			ec.screencastFrameChan <- ev.(*page.EventScreencastFrame)
 
 		case *debugger.EventScriptParsed:
 			ec.scriptParsedChan <- ev.(*debugger.EventScriptParsed)
+
+		case *runtime.EventConsoleAPICalled:
+			ec.consoleAPICalledChan <- ev.(*runtime.EventConsoleAPICalled)
+		case *runtime.EventExceptionThrown:
+			ec.exceptionThrownChan <- ev.(*runtime.EventExceptionThrown)
+		case *runtime.EventBindingCalled:
+			ec.bindingCalledChan <- ev.(*runtime.EventBindingCalled)
+
+		case *browser.EventDownloadWillBegin:
+			ec.downloadWillBeginChan <- ev.(*browser.EventDownloadWillBegin)
+		case *browser.EventDownloadProgress:
+			ec.downloadProgressChan <- ev.(*browser.EventDownloadProgress)
+
+		case *cdplog.EventEntryAdded:
+			ec.logEntryAddedChan <- ev.(*cdplog.EventEntryAdded)
 		}
 	})
 
+	// Start recording before navigation. The browser stops sending frames until each one is acknowledged, so
+	// this has to wait until the listener above is in place to pass frames along to their handler.
+	if *tw.SanitizedTask.DS.Screencast {
+		err = chromedp.Run(browserContext, chromedp.ActionFunc(func(cxt context.Context) error {
+			return page.StartScreencast().WithFormat(page.ScreencastFormatJpeg).
+				WithQuality(b.DefaultScreencastQuality).WithEveryNthFrame(1).Do(cxt)
+		}))
+		if err != nil {
+			tw.Log.Warn("failed to start screencast: ", err)
+		}
+	}
+
+	// Have the browser hold each new target until we let it go, so we can attach to popups before they
+	// make any requests. Auto-attaching at the browser level reports new targets to the browser itself,
+	// rather than to the page.
+	if *tw.SanitizedTask.IS.FollowPopups {
+		chromedp.ListenBrowser(browserContext, func(ev interface{}) {
+			switch ev.(type) {
+			case *target.EventAttachedToTarget:
+				ec.attachedToTargetChan <- ev.(*target.EventAttachedToTarget)
+			}
+		})
+
+		err = chromedp.Run(browserContext, chromedp.ActionFunc(func(cxt context.Context) error {
+			return target.SetAutoAttach(true, true).WithFlatten(true).
+				Do(cdp.WithExecutor(cxt, chromedp.FromContext(cxt).Browser))
+		}))
+		if err != nil {
+			tw.Log.Warn("failed to set up attaching to popups: ", err)
+		}
+	}
+
 	// Initiate navigation to the applicable page
 	go func() {
 		err = chromedp.Run(browserContext, chromedp.ActionFunc(func(ctxt context.Context) error {
@@ -300,6 +525,7 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 		log.Log.Errorf("failed to navigate to site: " + errorCode)
 
 		// We have failed to navigate to the site. Shut things down.
+		stopPopups(&devToolsState)
 		closeContext, _ := context.WithTimeout(browserContext, 5*time.Second)
 		err = chromedp.Cancel(closeContext)
 		if err != nil {
@@ -325,6 +551,13 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 		// Browser crashed, closed manually, or we otherwise lost connection to it prematurely
 		tw.Log.Warn("browser crashed, closed manually, or we lost connection")
 	case <-loadEventChan:
+		err = chromedp.Run(browserContext, chromedp.ActionFunc(func(cxt context.Context) error {
+			return removeSeedScript(cxt, &devToolsState)
+		}))
+		if err != nil {
+			tw.Log.Warn("failed to remove local storage seed script: ", err)
+		}
+
 		// The load event fired. What we do next depends on how the crawl completes
 		switch *tw.SanitizedTask.CS.CompletionCondition {
 		case b.TimeAfterLoad:
@@ -369,9 +602,41 @@ func VisitPageDevtoolsProtocol(tw *b.TaskWrapper) (*b.RawResult, error) {
 		tw.Log.Debug("general timeout before load event fired")
 	}
 
+	// Popups need the browser to finish up, so they must be done before we close it
+	stopPopups(&devToolsState)
+
 	tw.Log.Debug("closing browser")
 	closeContext, _ := context.WithTimeout(browserContext, 60*time.Second)
 	err = chromedp.Run(closeContext, chromedp.ActionFunc(func(ctxt context.Context) error {
+		if *tw.SanitizedTask.DS.JSCoverage {
+			coverage, _, err := profiler.TakePreciseCoverage().Do(ctxt)
+			if err != nil {
+				tw.Log.Error("failed to take JavaScript coverage: ", err)
+			} else {
+				rawResult.Lock()
+				rawResult.DevTools.JSCoverage = coverage
+				rawResult.Unlock()
+			}
+		}
+
+		if *tw.SanitizedTask.DS.WebStorage {
+			webStorage, err := getWebStorage(ctxt, tw.Log)
+			if err != nil {
+				tw.Log.Error("failed to gather web storage: ", err)
+			} else {
+				rawResult.Lock()
+				rawResult.DevTools.WebStorage = webStorage
+				rawResult.Unlock()
+			}
+		}
+
+		if *tw.SanitizedTask.DS.Screencast {
+			err := page.StopScreencast().Do(ctxt)
+			if err != nil {
+				tw.Log.Warn("failed to stop screencast: ", err)
+			}
+		}
+
 		_, entries, err := page.GetNavigationHistory().Do(ctxt)
 		if err != nil {
 			return err
@@ -427,13 +692,92 @@ func openEventChannels() EventChannels {
 		webSocketHandshakeResponseReceivedChan: make(chan *network.EventWebSocketHandshakeResponseReceived, b.DefaultEventChannelBufferSize),
 		EventSourceMessageReceivedChan:         make(chan *network.EventEventSourceMessageReceived, b.DefaultEventChannelBufferSize),
 		requestPausedChan:                      make(chan *fetch.EventRequestPaused, b.DefaultEventChannelBufferSize),
+		authRequiredChan:                       make(chan *fetch.EventAuthRequired, b.DefaultEventChannelBufferSize),
 		scriptParsedChan:                       make(chan *debugger.EventScriptParsed, b.DefaultEventChannelBufferSize),
 		targetCreatedChan:                      make(chan *target.EventTargetCreated, b.DefaultEventChannelBufferSize),
+		attachedToTargetChan:                   make(chan *target.EventAttachedToTarget, b.DefaultEventChannelBufferSize),
+		consoleAPICalledChan:                   make(chan *runtime.EventConsoleAPICalled, b.DefaultEventChannelBufferSize),
+		exceptionThrownChan:                    make(chan *runtime.EventExceptionThrown, b.DefaultEventChannelBufferSize),
+		bindingCalledChan:                      make(chan *runtime.EventBindingCalled, b.DefaultEventChannelBufferSize),
+		logEntryAddedChan:                      make(chan *cdplog.EventEntryAdded, b.DefaultEventChannelBufferSize),
+		screencastFrameChan:                    make(chan *page.EventScreencastFrame, b.DefaultEventChannelBufferSize),
+		frameAttachedChan:                      make(chan *page.EventFrameAttached, b.DefaultEventChannelBufferSize),
+		frameDetachedChan:                      make(chan *page.EventFrameDetached, b.DefaultEventChannelBufferSize),
+		targetInfoChangedChan:                  make(chan *target.EventTargetInfoChanged, b.DefaultEventChannelBufferSize),
+		downloadWillBeginChan:                  make(chan *browser.EventDownloadWillBegin, b.DefaultEventChannelBufferSize),
+		downloadProgressChan:                   make(chan *browser.EventDownloadProgress, b.DefaultEventChannelBufferSize),
 	}
 
 	return ec
 }
 
+// proxyAuthentication returns true if we have credentials to answer proxy authentication challenges with
+func proxyAuthentication(tw *b.TaskWrapper) bool {
+	return tw.SanitizedTask.Proxy != nil && (tw.SanitizedTask.Proxy.Username != "" || tw.SanitizedTask.Proxy.Password != "")
+}
+
+// prepareTarget applies the settings which DevTools scopes to a single target, before it navigates: network
+// conditions, request interception, emulation, the user agent and extra headers, and init scripts. It is used for
+// the page itself and for each popup we follow, returning the user agent the target will send.
+func prepareTarget(cxt context.Context, tw *b.TaskWrapper, devtoolsState *DTState, defaultUserAgent string) (string, error) {
+	st := &tw.SanitizedTask
+	if st.NetworkConditions != nil {
+		err := applyNetworkConditions(cxt, st.NetworkConditions)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Request rules, filter list blocking, replay and proxy authentication must apply from the very first request,
+	// so we intercept everything from the start
+	if len(st.RequestRules) > 0 || st.BlockFilterMatches || devtoolsState.replay != nil || proxyAuthentication(tw) {
+		err := enableFetch(cxt, tw)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if st.Emulation != nil {
+		err := applyEmulation(cxt, st.Emulation)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	userAgent := defaultUserAgent
+	if st.UserAgent != "" || st.AcceptLanguage != "" {
+		var err error
+		userAgent, err = applyUserAgent(cxt, st, defaultUserAgent)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(st.ExtraHTTPHeaders) > 0 {
+		headers := make(network.Headers)
+		for k, v := range st.ExtraHTTPHeaders {
+			headers[k] = v
+		}
+		err := network.SetExtraHTTPHeaders(headers).Do(cxt)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err := installInitScripts(cxt, st)
+	if err != nil {
+		return "", err
+	}
+
+	return userAgent, nil
+}
+
+// enableFetch enables request interception. Enabling it again replaces the previous configuration,
+// so every caller must go through here to keep answering proxy authentication challenges.
+func enableFetch(cxt context.Context, tw *b.TaskWrapper) error {
+	return fetch.Enable().WithHandleAuthRequests(proxyAuthentication(tw)).Do(cxt)
+}
+
 // ChromeFormatFlag takes a variety of possible flag formats and puts them in a format that chromedp understands (key/value)
 func ChromeFormatFlag(f string) (string, interface{}, error) {
 	if strings.HasPrefix(f, "--") {
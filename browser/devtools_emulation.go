@@ -0,0 +1,129 @@
+package browser
+
+import (
+	"context"
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/network"
+	b "github.com/teamnsrg/mida/base"
+)
+
+// Maximum number of touch points reported when emulating a touch screen
+const emulatedMaxTouchPoints = 5
+
+// applyEmulation applies the device and environment settings for a task. It must be run before navigation.
+// The user agent of the emulated device is applied separately, along with any other user agent settings.
+func applyEmulation(cxt context.Context, em *b.SanitizedEmulation) error {
+	var err error
+	if em.Width > 0 && em.Height > 0 {
+		err = emulation.SetDeviceMetricsOverride(em.Width, em.Height, em.DeviceScaleFactor, em.Mobile).Do(cxt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if em.Touch {
+		err = emulation.SetTouchEmulationEnabled(true).WithMaxTouchPoints(emulatedMaxTouchPoints).Do(cxt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if em.Timezone != "" {
+		err = emulation.SetTimezoneOverride(em.Timezone).Do(cxt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if em.Locale != "" {
+		err = emulation.SetLocaleOverride().WithLocale(em.Locale).Do(cxt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if g := em.Geolocation; g != nil {
+		// Pages only see the position if they have permission to ask for it
+		err = browser.GrantPermissions([]browser.PermissionType{browser.PermissionTypeGeolocation}).Do(cxt)
+		if err != nil {
+			return err
+		}
+
+		err = emulation.SetGeolocationOverride().WithLatitude(g.Latitude).
+			WithLongitude(g.Longitude).WithAccuracy(g.Accuracy).Do(cxt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyUserAgent overrides the user agent, Accept-Language header and client hints sent by the browser, returning
+// the user agent which will actually be sent. defaultUserAgent is the browser's own user agent, which we keep
+// if the task only overrides the Accept-Language header.
+func applyUserAgent(cxt context.Context, st *b.SanitizedTask, defaultUserAgent string) (string, error) {
+	userAgent := st.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+
+	params := emulation.SetUserAgentOverride(userAgent)
+	if st.AcceptLanguage != "" {
+		params = params.WithAcceptLanguage(st.AcceptLanguage)
+	}
+	if st.ClientHints != nil {
+		mobile := st.Emulation != nil && st.Emulation.Mobile
+		params = params.WithUserAgentMetadata(userAgentMetadata(st.ClientHints, mobile))
+	}
+
+	return userAgent, params.Do(cxt)
+}
+
+// userAgentMetadata converts client hints into the form expected by the Emulation domain
+func userAgentMetadata(hints *b.ClientHints, mobile bool) *emulation.UserAgentMetadata {
+	metadata := &emulation.UserAgentMetadata{
+		Brands:          make([]*emulation.UserAgentBrandVersion, 0, len(hints.Brands)),
+		Platform:        hints.Platform,
+		PlatformVersion: hints.PlatformVersion,
+		Architecture:    hints.Architecture,
+		Model:           hints.Model,
+		Mobile:          mobile,
+	}
+	for _, brand := range hints.Brands {
+		metadata.Brands = append(metadata.Brands, &emulation.UserAgentBrandVersion{
+			Brand:   brand.Brand,
+			Version: brand.Version,
+		})
+	}
+
+	return metadata
+}
+
+// applyNetworkConditions throttles the network (and CPU) for a task. The Network domain must already be enabled.
+func applyNetworkConditions(cxt context.Context, nc *b.SanitizedNetworkConditions) error {
+	err := network.EmulateNetworkConditions(nc.Offline, nc.Latency,
+		throughputBytes(nc.DownloadThroughput), throughputBytes(nc.UploadThroughput)).Do(cxt)
+	if err != nil {
+		return err
+	}
+
+	if nc.CPUThrottlingRate > 1 {
+		err = emulation.SetCPUThrottlingRate(nc.CPUThrottlingRate).Do(cxt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// throughputBytes converts a throughput in kilobits per second to the bytes per second expected by
+// the Network domain, where -1 disables throttling
+func throughputBytes(kbps float64) float64 {
+	if kbps == 0 {
+		return -1
+	}
+	return kbps * 1000 / 8
+}
@@ -2,11 +2,17 @@ package browser
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/chromedp/cdproto/browser"
 	"github.com/chromedp/cdproto/debugger"
 	"github.com/chromedp/cdproto/fetch"
+	cdplog "github.com/chromedp/cdproto/log"
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/cdproto/target"
 	"github.com/sirupsen/logrus"
 	"github.com/teamnsrg/chromedp"
@@ -57,8 +63,9 @@ func PageLoadEventFired(eventChan chan *page.EventLoadEventFired, loadEventChan
 	wg.Done()
 }
 
-func PageFrameNavigated(eventChan chan *page.EventFrameNavigated, devtoolsState *DTState, wg *sync.WaitGroup, ctxt context.Context) {
+func PageFrameNavigated(eventChan chan *page.EventFrameNavigated, rawResult *b.RawResult, devtoolsState *DTState, wg *sync.WaitGroup, ctxt context.Context) {
 	done := false
+	enabled := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.Frames
 	for {
 		select {
 		case ev, ok := <-eventChan:
@@ -67,6 +74,12 @@ func PageFrameNavigated(eventChan chan *page.EventFrameNavigated, devtoolsState
 				break
 			}
 
+			if enabled {
+				rawResult.Lock()
+				rawResult.DevTools.Frames.Navigated = append(rawResult.DevTools.Frames.Navigated, ev)
+				rawResult.Unlock()
+			}
+
 			// Keep track of the frame at the top level, so we can block navigations when needed
 			if ev.Frame.ParentID == "" {
 				devtoolsState.Lock()
@@ -87,6 +100,61 @@ func PageFrameNavigated(eventChan chan *page.EventFrameNavigated, devtoolsState
 	wg.Done()
 }
 
+// PageFrames records frames being attached to and detached from the page, along with navigations
+// of cross-origin frames which run in their own targets
+func PageFrames(ec *EventChannels, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+	enabled := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.Frames
+	frames := &rawResult.DevTools.Frames
+	for {
+		select {
+		case ev, ok := <-ec.frameAttachedChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled {
+				rawResult.Lock()
+				frames.Attached = append(frames.Attached, ev)
+				rawResult.Unlock()
+			}
+
+		case ev, ok := <-ec.frameDetachedChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled {
+				rawResult.Lock()
+				frames.Detached = append(frames.Detached, ev)
+				rawResult.Unlock()
+			}
+
+		case ev, ok := <-ec.targetInfoChangedChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			// The target ID of an out-of-process iframe is the ID of its frame
+			if enabled && ev.TargetInfo != nil && ev.TargetInfo.Type == "iframe" {
+				rawResult.Lock()
+				frames.IframeTargets = append(frames.IframeTargets, ev.TargetInfo)
+				rawResult.Unlock()
+			}
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
 // PageJavaScriptDialogOpening handles JavaScript dialog events, for now simply dismissing them so data collection can continue
 func PageJavaScriptDialogOpening(eventChan chan *page.EventJavascriptDialogOpening, wg *sync.WaitGroup, ctxt context.Context, log *logrus.Logger) {
 	done := false
@@ -123,7 +191,7 @@ func PageJavaScriptDialogOpening(eventChan chan *page.EventJavascriptDialogOpeni
 }
 
 // NetworkRequestWillBeSent is the event handler for the Network.RequestWillBeSent event
-func NetworkRequestWillBeSent(eventChan chan *network.EventRequestWillBeSent, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+func NetworkRequestWillBeSent(eventChan chan *network.EventRequestWillBeSent, rawResult *b.RawResult, devtoolsState *DTState, wg *sync.WaitGroup, ctxt context.Context) {
 	done := false
 	for {
 		select {
@@ -133,12 +201,19 @@ func NetworkRequestWillBeSent(eventChan chan *network.EventRequestWillBeSent, ra
 				break
 			}
 
+			filterMatch := matchFilterLists(devtoolsState, ev.Request.URL, ev.DocumentURL, ev.Type, ev.FrameID)
+
 			rawResult.Lock()
 			if _, ok := rawResult.DevTools.Network.RequestWillBeSent[ev.RequestID.String()]; !ok {
 				rawResult.DevTools.Network.RequestWillBeSent[ev.RequestID.String()] = make([]*network.EventRequestWillBeSent, 0)
 			}
 			rawResult.DevTools.Network.RequestWillBeSent[ev.RequestID.String()] = append(
 				rawResult.DevTools.Network.RequestWillBeSent[ev.RequestID.String()], ev)
+
+			// For redirect chains, we keep the first hop which matched a filter
+			if _, ok := rawResult.DevTools.Network.FilterMatches[ev.RequestID.String()]; !ok && filterMatch != nil {
+				rawResult.DevTools.Network.FilterMatches[ev.RequestID.String()] = filterMatch
+			}
 			rawResult.Unlock()
 
 		case <-ctxt.Done(): // Context canceled, browser closed
@@ -187,6 +262,8 @@ func NetworkLoadingFinished(eventChan chan *network.EventLoadingFinished, rawRes
 	done := false
 	resourceDownloadSuccessCounter := 0
 	resourceDownloadAttemptCounter := 0
+	ds := rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS
+	downloadResources := *ds.AllResources || *ds.WARC
 	for {
 		select {
 		case ev, ok := <-eventChan:
@@ -195,8 +272,12 @@ func NetworkLoadingFinished(eventChan chan *network.EventLoadingFinished, rawRes
 				break
 			}
 
+			rawResult.Lock()
+			rawResult.DevTools.Network.LoadingFinished[ev.RequestID.String()] = ev
+			rawResult.Unlock()
+
 			// Skip downloading the resource if we aren't gathering them
-			if !*rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.AllResources {
+			if !downloadResources {
 				break
 			}
 
@@ -233,7 +314,7 @@ func NetworkLoadingFinished(eventChan chan *network.EventLoadingFinished, rawRes
 		}
 	}
 
-	if *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.AllResources {
+	if downloadResources {
 		log.Debugf("successfully downloaded %d out of %d resources",
 			resourceDownloadSuccessCounter, resourceDownloadAttemptCounter)
 	}
@@ -241,6 +322,359 @@ func NetworkLoadingFinished(eventChan chan *network.EventLoadingFinished, rawRes
 	wg.Done()
 }
 
+// NetworkWebSocket is the event handler for all of the Network.webSocket* events. Because the frames of a
+// single websocket may be split across the sent and received events, we handle them all in a single goroutine
+// so that frames are recorded in the order in which they arrive.
+func NetworkWebSocket(ec *EventChannels, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+	enabled := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.WebSocket
+	ws := &rawResult.DevTools.WebSockets
+	for {
+		select {
+		case ev, ok := <-ec.webSocketCreatedChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled {
+				rawResult.Lock()
+				ws.Created[ev.RequestID.String()] = ev
+				rawResult.Unlock()
+			}
+
+		case ev, ok := <-ec.webSocketWillSendHandshakeRequestChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled {
+				rawResult.Lock()
+				ws.HandshakeRequest[ev.RequestID.String()] = ev
+				rawResult.Unlock()
+			}
+
+		case ev, ok := <-ec.webSocketHandshakeResponseReceivedChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled {
+				rawResult.Lock()
+				ws.HandshakeResponse[ev.RequestID.String()] = ev
+				rawResult.Unlock()
+			}
+
+		case ev, ok := <-ec.webSocketFrameSentChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled && ev.Response != nil {
+				rawResult.Lock()
+				ws.Frames[ev.RequestID.String()] = append(ws.Frames[ev.RequestID.String()], &b.DTWebSocketFrame{
+					Direction:   b.WebSocketFrameSent,
+					Timestamp:   ev.Timestamp,
+					Opcode:      ev.Response.Opcode,
+					Mask:        ev.Response.Mask,
+					PayloadData: ev.Response.PayloadData,
+				})
+				rawResult.Unlock()
+			}
+
+		case ev, ok := <-ec.webSocketFrameReceivedChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled && ev.Response != nil {
+				rawResult.Lock()
+				ws.Frames[ev.RequestID.String()] = append(ws.Frames[ev.RequestID.String()], &b.DTWebSocketFrame{
+					Direction:   b.WebSocketFrameReceived,
+					Timestamp:   ev.Timestamp,
+					Opcode:      ev.Response.Opcode,
+					Mask:        ev.Response.Mask,
+					PayloadData: ev.Response.PayloadData,
+				})
+				rawResult.Unlock()
+			}
+
+		case ev, ok := <-ec.webSocketFrameErrorChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled {
+				rawResult.Lock()
+				ws.FrameErrors[ev.RequestID.String()] = append(ws.FrameErrors[ev.RequestID.String()], ev)
+				rawResult.Unlock()
+			}
+
+		case ev, ok := <-ec.webSocketClosedChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled {
+				rawResult.Lock()
+				ws.Closed[ev.RequestID.String()] = ev
+				rawResult.Unlock()
+			}
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
+// NetworkEventSourceMessageReceived is the event handler for Network.eventSourceMessageReceived events
+func NetworkEventSourceMessageReceived(eventChan chan *network.EventEventSourceMessageReceived, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+	enabled := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.EventSourceMessages
+	for {
+		select {
+		case ev, ok := <-eventChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+
+			if !enabled {
+				break
+			}
+
+			rawResult.Lock()
+			rawResult.DevTools.Network.EventSourceMessageReceived[ev.RequestID.String()] = append(
+				rawResult.DevTools.Network.EventSourceMessageReceived[ev.RequestID.String()], ev)
+			rawResult.Unlock()
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
+// RuntimeConsoleAPICalled is the event handler for Runtime.consoleAPICalled events
+func RuntimeConsoleAPICalled(eventChan chan *runtime.EventConsoleAPICalled, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+
+	// Runtime may also be enabled for init scripts, so we check that we are actually gathering console output
+	collect := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.Console
+	for {
+		select {
+		case ev, ok := <-eventChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+
+			if !collect {
+				continue
+			}
+
+			rawResult.Lock()
+			rawResult.DevTools.Console.ConsoleAPICalls = append(rawResult.DevTools.Console.ConsoleAPICalls, ev)
+			rawResult.Unlock()
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
+// RuntimeExceptionThrown is the event handler for Runtime.exceptionThrown events
+func RuntimeExceptionThrown(eventChan chan *runtime.EventExceptionThrown, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+
+	// Exceptions are part of the console output, so they are only kept if we are gathering it
+	collect := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.Console
+	for {
+		select {
+		case ev, ok := <-eventChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+
+			if !collect {
+				continue
+			}
+
+			rawResult.Lock()
+			rawResult.DevTools.Console.Exceptions = append(rawResult.DevTools.Console.Exceptions, ev)
+			rawResult.Unlock()
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
+// RuntimeBindingCalled is the event handler for Runtime.bindingCalled events, through which init scripts report
+// records to us. Payloads which are not valid JSON are kept as strings.
+func RuntimeBindingCalled(eventChan chan *runtime.EventBindingCalled, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+	dropped := 0
+	for {
+		select {
+		case ev, ok := <-eventChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+
+			if ev.Name != b.DefaultInstrumentationBinding {
+				continue
+			}
+
+			record := json.RawMessage(ev.Payload)
+			if !json.Valid(record) {
+				record, _ = json.Marshal(ev.Payload)
+			}
+
+			rawResult.Lock()
+			if len(rawResult.DevTools.Instrumentation) < b.DefaultMaxInstrumentationRecords {
+				rawResult.DevTools.Instrumentation = append(rawResult.DevTools.Instrumentation, &b.DTInstrumentationRecord{
+					Timestamp:          time.Now(),
+					ExecutionContextID: int64(ev.ExecutionContextID),
+					Record:             record,
+				})
+			} else {
+				dropped += 1
+			}
+			rawResult.Unlock()
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	if dropped > 0 {
+		rawResult.TaskSummary.TaskWrapper.Log.Warnf("dropped %d instrumentation records over the limit", dropped)
+	}
+
+	wg.Done()
+}
+
+// LogEntryAdded is the event handler for Log.entryAdded events
+func LogEntryAdded(eventChan chan *cdplog.EventEntryAdded, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+
+	// chromedp enables the Log domain on every target, so we see these even when we are not gathering console output
+	collect := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.Console
+	for {
+		select {
+		case ev, ok := <-eventChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+
+			if !collect {
+				continue
+			}
+
+			rawResult.Lock()
+			rawResult.DevTools.Console.LogEntries = append(rawResult.DevTools.Console.LogEntries, ev)
+			rawResult.Unlock()
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
+// PageScreencastFrame is the event handler for Page.screencastFrame events. Each frame is written to the
+// screencast directory and acknowledged, since the browser stops sending frames until we do.
+func PageScreencastFrame(eventChan chan *page.EventScreencastFrame, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+	tw := rawResult.TaskSummary.TaskWrapper
+	for {
+		select {
+		case ev, ok := <-eventChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+
+			err := chromedp.Run(ctxt, chromedp.ActionFunc(func(cxt context.Context) error {
+				return page.ScreencastFrameAck(ev.SessionID).Do(cxt)
+			}))
+			if err != nil {
+				tw.Log.Warn("failed to acknowledge screencast frame: " + err.Error())
+			}
+
+			data, err := base64.StdEncoding.DecodeString(ev.Data)
+			if err != nil {
+				tw.Log.Error("failed to decode screencast frame: " + err.Error())
+				break
+			}
+
+			rawResult.Lock()
+			frame := &b.DTScreencastFrame{
+				File:     fmt.Sprintf("%05d.jpg", len(rawResult.DevTools.Screencast)),
+				Metadata: ev.Metadata,
+			}
+			rawResult.DevTools.Screencast = append(rawResult.DevTools.Screencast, frame)
+			rawResult.Unlock()
+
+			err = ioutil.WriteFile(path.Join(tw.TempDir, b.DefaultScreencastSubdir, frame.File), data, 0644)
+			if err != nil {
+				tw.Log.Error("failed to write screencast frame: " + err.Error())
+			}
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
 // FetchRequestPaused is the event handler for network requests which have been paused
 func FetchRequestPaused(eventChan chan *fetch.EventRequestPaused, rawResult *b.RawResult, devtoolsState *DTState, wg *sync.WaitGroup, ctxt context.Context) {
 	done := false
@@ -253,13 +687,17 @@ func FetchRequestPaused(eventChan chan *fetch.EventRequestPaused, rawResult *b.R
 				break
 			}
 
+			// Navigation locking only kicks in once the load event has fired
+			rawResult.Lock()
+			navigationLocked := *tw.SanitizedTask.IS.LockNavigation && !rawResult.TaskSummary.TaskTiming.LoadEvent.IsZero()
+			rawResult.Unlock()
+
 			err := chromedp.Run(ctxt, chromedp.ActionFunc(func(cxt context.Context) error {
 				devtoolsState.Lock()
 				mainFrame := devtoolsState.mainFrameLoaderId
 				devtoolsState.Unlock()
 
-				var err error
-				if ev.ResourceType == network.ResourceTypeDocument {
+				if navigationLocked && ev.ResourceType == network.ResourceTypeDocument {
 					var frameId string
 					rawResult.Lock()
 					if _, ok := rawResult.DevTools.Network.RequestWillBeSent[ev.NetworkID.String()]; ok {
@@ -269,16 +707,79 @@ func FetchRequestPaused(eventChan chan *fetch.EventRequestPaused, rawResult *b.R
 					rawResult.Unlock()
 
 					if frameId == mainFrame {
-						err = fetch.FailRequest(ev.RequestID, network.ErrorReasonAborted).Do(cxt)
 						log.Log.WithField("URL", tw.SanitizedTask.URL).Debug("denying navigation to " + ev.Request.URL)
-					} else {
-						err = fetch.ContinueRequest(ev.RequestID).Do(cxt)
+						return fetch.FailRequest(ev.RequestID, network.ErrorReasonAborted).Do(cxt)
 					}
-				} else {
-					err = fetch.ContinueRequest(ev.RequestID).Do(cxt)
 				}
 
-				return err
+				// Explicit request rules take precedence over everything else. When replaying, requests which a
+				// rule lets through are answered from the recording below instead of going out to the network.
+				rule := matchRequestRule(tw.SanitizedTask.RequestRules, ev)
+				if rule != nil {
+					replayed := devtoolsState.replay != nil &&
+						(rule.Action == b.RequestRuleContinue || rule.Action == b.RequestRuleRewriteHeaders)
+					var err error
+					if !replayed {
+						err = applyRequestRule(cxt, rule, ev)
+					}
+
+					hit := &b.DTRequestRuleHit{
+						Rule:         rule.Name,
+						RequestID:    ev.NetworkID.String(),
+						URL:          ev.Request.URL,
+						ResourceType: ev.ResourceType,
+						Action:       rule.Action,
+						Timestamp:    time.Now(),
+					}
+					if err != nil {
+						hit.Error = err.Error()
+					}
+					rawResult.Lock()
+					rawResult.DevTools.RequestRuleHits = append(rawResult.DevTools.RequestRuleHits, hit)
+					rawResult.Unlock()
+
+					if !replayed {
+						return err
+					}
+				}
+
+				if rule == nil && tw.SanitizedTask.BlockFilterMatches {
+					// We may not have seen the corresponding Network.requestWillBeSent yet, in which case
+					// we assume the request comes from the page we visited
+					documentURL := tw.SanitizedTask.URL
+					rawResult.Lock()
+					if rArr, ok := rawResult.DevTools.Network.RequestWillBeSent[ev.NetworkID.String()]; ok {
+						documentURL = rArr[len(rArr)-1].DocumentURL
+					}
+					rawResult.Unlock()
+
+					filterMatch := matchFilterLists(devtoolsState, ev.Request.URL, documentURL, ev.ResourceType, ev.FrameID)
+					if filterMatch != nil && filterMatch.Exception == "" {
+						rawResult.Lock()
+						rawResult.DevTools.Network.FilterBlocked[ev.NetworkID.String()] = true
+						rawResult.Unlock()
+						return fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient).Do(cxt)
+					}
+				}
+
+				// When replaying, nothing goes out to the live network
+				if devtoolsState.replay != nil {
+					resp := devtoolsState.replay.lookup(ev.Request.Method, ev.Request.URL)
+					if resp == nil {
+						tw.Log.Warn("no recorded response to replay for " + ev.Request.Method + " " + ev.Request.URL)
+						rawResult.Lock()
+						rawResult.TaskSummary.ReplayData.Misses = append(rawResult.TaskSummary.ReplayData.Misses, ev.Request.URL)
+						rawResult.Unlock()
+						return fetch.FailRequest(ev.RequestID, network.ErrorReasonInternetDisconnected).Do(cxt)
+					}
+
+					rawResult.Lock()
+					rawResult.TaskSummary.ReplayData.NumFulfilled += 1
+					rawResult.Unlock()
+					return resp.fulfill(cxt, ev.RequestID)
+				}
+
+				return fetch.ContinueRequest(ev.RequestID).Do(cxt)
 			}))
 			if err != nil {
 				tw.Log.Error("failed to continue a paused request: " + err.Error())
@@ -297,8 +798,69 @@ func FetchRequestPaused(eventChan chan *fetch.EventRequestPaused, rawResult *b.R
 	wg.Done()
 }
 
-func TargetTargetCreated(eventChan chan *target.EventTargetCreated, wg *sync.WaitGroup, ctxt context.Context, Url string) {
+// FetchAuthRequired is the event handler for authentication challenges, which we only see when we have
+// credentials for the proxy. Proxy challenges are answered with those credentials (once per request, so the
+// browser gives up if they are rejected), and everything else gets the browser's default behavior.
+func FetchAuthRequired(eventChan chan *fetch.EventAuthRequired, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+	tw := rawResult.TaskSummary.TaskWrapper
+	answered := make(map[fetch.RequestID]bool)
+	for {
+		select {
+		case ev, ok := <-eventChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+
+			response := proxyAuthResponse(ev, tw.SanitizedTask.Proxy, answered)
+			if response.Response == fetch.AuthChallengeResponseResponseCancelAuth {
+				tw.Log.Warn("proxy rejected our credentials for " + ev.Request.URL)
+			}
+
+			err := chromedp.Run(ctxt, fetch.ContinueWithAuth(ev.RequestID, response))
+			if err != nil {
+				tw.Log.Error("failed to answer authentication challenge: " + err.Error())
+			}
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
+// proxyAuthResponse decides how to answer an authentication challenge. Proxy challenges get our credentials the
+// first time we see them for a request, and are canceled after that, since the proxy must have rejected them.
+func proxyAuthResponse(ev *fetch.EventAuthRequired, proxy *b.SanitizedProxy, answered map[fetch.RequestID]bool) *fetch.AuthChallengeResponse {
+	response := &fetch.AuthChallengeResponse{
+		Response: fetch.AuthChallengeResponseResponseDefault,
+	}
+	if ev.AuthChallenge == nil || ev.AuthChallenge.Source != fetch.AuthChallengeSourceProxy || proxy == nil {
+		return response
+	}
+
+	if answered[ev.RequestID] {
+		response.Response = fetch.AuthChallengeResponseResponseCancelAuth
+	} else {
+		response.Response = fetch.AuthChallengeResponseResponseProvideCredentials
+		response.Username = proxy.Username
+		response.Password = proxy.Password
+		answered[ev.RequestID] = true
+	}
+
+	return response
+}
+
+func TargetTargetCreated(eventChan chan *target.EventTargetCreated, rawResult *b.RawResult, devtoolsState *DTState, wg *sync.WaitGroup, ctxt context.Context) {
 	done := false
+	tw := rawResult.TaskSummary.TaskWrapper
 	for {
 		select {
 		case ev, ok := <-eventChan:
@@ -307,23 +869,59 @@ func TargetTargetCreated(eventChan chan *target.EventTargetCreated, wg *sync.Wai
 				break
 			}
 
+			// When we follow popups, we deal with them once we are attached to them (see TargetAttachedToTarget)
+			if *tw.SanitizedTask.IS.FollowPopups && ev.TargetInfo.Type == "page" && ev.TargetInfo.OpenerID != "" {
+				break
+			}
+
 			// Prevent new tabs from opening up
 			if ev.TargetInfo.URL != "about:blank" && ev.TargetInfo.Type == "page" {
-				err := chromedp.Run(ctxt, chromedp.ActionFunc(func(cxt context.Context) error {
-					log.Log.Debug("closing newly opened target " + ev.TargetInfo.URL)
-					err := target.CloseTarget(ev.TargetInfo.TargetID).Do(cxt)
-					if err != nil {
-						errString := "failed to close new target"
-						if err != nil {
-							errString += ": " + err.Error()
-						}
-						return errors.New(errString)
-					}
-					return err
-				}))
-				if err != nil {
-					log.Log.WithField("URL", Url).Error(err)
+				closeNewTarget(ctxt, ev.TargetInfo, tw)
+			}
+
+		case <-ctxt.Done(): // Context canceled
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
+// TargetAttachedToTarget handles the new targets which the browser holds until we let them go, which we only see
+// when following popups. Popups are followed (up to the limit) or closed, and any other target is let go as it is.
+func TargetAttachedToTarget(eventChan chan *target.EventAttachedToTarget, rawResult *b.RawResult, devtoolsState *DTState, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+	tw := rawResult.TaskSummary.TaskWrapper
+	for {
+		select {
+		case ev, ok := <-eventChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+
+			// Targets which already existed, along with those we attach to ourselves, are not being held
+			if !ev.WaitingForDebugger || ev.TargetInfo == nil {
+				break
+			}
+
+			if ev.TargetInfo.Type == "page" && ev.TargetInfo.OpenerID != "" {
+				if index, ok := startPopup(devtoolsState, *tw.SanitizedTask.IS.MaxPopups); ok {
+					go followPopup(ctxt, ev.TargetInfo, ev.SessionID, index, rawResult, devtoolsState)
+				} else {
+					closeNewTarget(ctxt, ev.TargetInfo, tw)
 				}
+				break
+			}
+
+			err := releaseTarget(ctxt, ev.SessionID)
+			if err != nil {
+				tw.Log.Warn("failed to release new target " + ev.TargetInfo.URL + ": " + err.Error())
 			}
 
 		case <-ctxt.Done(): // Context canceled
@@ -339,6 +937,90 @@ func TargetTargetCreated(eventChan chan *target.EventTargetCreated, wg *sync.Wai
 	wg.Done()
 }
 
+// closeNewTarget closes a tab opened by the page, which we are not going to follow
+func closeNewTarget(ctxt context.Context, info *target.Info, tw *b.TaskWrapper) {
+	err := chromedp.Run(ctxt, chromedp.ActionFunc(func(cxt context.Context) error {
+		log.Log.Debug("closing newly opened target " + info.URL)
+		err := target.CloseTarget(info.TargetID).Do(cxt)
+		if err != nil {
+			return errors.New("failed to close new target: " + err.Error())
+		}
+		return nil
+	}))
+	if err != nil {
+		log.Log.WithField("URL", tw.SanitizedTask.URL).Error(err)
+	}
+}
+
+// BrowserDownload records files downloaded by the page, canceling any download which grows beyond our size cap
+func BrowserDownload(ec *EventChannels, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
+	done := false
+	enabled := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.Downloads
+	for {
+		select {
+		case ev, ok := <-ec.downloadWillBeginChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if enabled {
+				rawResult.Lock()
+				rawResult.DevTools.Downloads[ev.GUID] = &b.DTDownload{
+					GUID:              ev.GUID,
+					URL:               ev.URL,
+					SuggestedFilename: ev.SuggestedFilename,
+					FrameID:           ev.FrameID.String(),
+					Began:             time.Now(),
+					State:             browser.DownloadProgressStateInProgress.String(),
+				}
+				rawResult.Unlock()
+			}
+
+		case ev, ok := <-ec.downloadProgressChan:
+			if !ok { // Channel closed
+				done = true
+				break
+			}
+			if !enabled {
+				break
+			}
+
+			rawResult.Lock()
+			download, ok := rawResult.DevTools.Downloads[ev.GUID]
+			if !ok {
+				rawResult.Unlock()
+				break
+			}
+			download.TotalBytes = ev.TotalBytes
+			download.ReceivedBytes = ev.ReceivedBytes
+			download.State = ev.State.String()
+			tooLarge := ev.State == browser.DownloadProgressStateInProgress && !download.ExceededSizeCap &&
+				(ev.ReceivedBytes > b.DefaultMaxDownloadSize || ev.TotalBytes > b.DefaultMaxDownloadSize)
+			if tooLarge {
+				download.ExceededSizeCap = true
+			}
+			rawResult.Unlock()
+
+			if tooLarge {
+				err := chromedp.Run(ctxt, browser.CancelDownload(ev.GUID))
+				if err != nil {
+					rawResult.TaskSummary.TaskWrapper.Log.Warn("failed to cancel oversized download: " + err.Error())
+				}
+			}
+
+		case <-ctxt.Done(): // Context canceled, browser closed
+			done = true
+			break
+		}
+
+		if done {
+			break
+		}
+	}
+
+	wg.Done()
+}
+
 // DebuggerScriptParsed is the event handler for network requests which have been paused
 func DebuggerScriptParsed(eventChan chan *debugger.EventScriptParsed, rawResult *b.RawResult, wg *sync.WaitGroup, ctxt context.Context) {
 	done := false
@@ -351,7 +1033,9 @@ func DebuggerScriptParsed(eventChan chan *debugger.EventScriptParsed, rawResult
 			}
 
 			rawResult.Lock()
-			scriptMetadata := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.ScriptMetadata
+			// Coverage is matched up with script metadata in postprocessing, so we need the scripts for either one
+			scriptMetadata := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.ScriptMetadata ||
+				*rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.JSCoverage
 			allScripts := *rawResult.TaskSummary.TaskWrapper.SanitizedTask.DS.AllScripts
 			scriptPath := path.Join(rawResult.TaskSummary.TaskWrapper.TempDir,
 				b.DefaultScriptSubdir, ev.ScriptID.String())
@@ -0,0 +1,37 @@
+package browser
+
+import (
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/teamnsrg/mida/adblock"
+	b "github.com/teamnsrg/mida/base"
+)
+
+// matchFilterLists matches a request against the filter lists loaded for the task, returning nil if no filter matches
+func matchFilterLists(devtoolsState *DTState, requestURL string, documentURL string,
+	resourceType network.ResourceType, frameID cdp.FrameID) *b.DTFilterMatch {
+	if devtoolsState.filters == nil {
+		return nil
+	}
+
+	// Document requests are only top-level if they are for the main frame (or precede it entirely)
+	devtoolsState.Lock()
+	mainFrame := devtoolsState.mainFrameLoaderId
+	devtoolsState.Unlock()
+	topLevel := mainFrame == "" || frameID.String() == mainFrame
+
+	block, exception := devtoolsState.filters.Match(requestURL, documentURL, adblock.RequestType(resourceType, topLevel))
+	if block == nil {
+		return nil
+	}
+
+	match := &b.DTFilterMatch{
+		Rule: block.Text,
+		List: block.List,
+	}
+	if exception != nil {
+		match.Exception = exception.Text
+	}
+
+	return match
+}
@@ -0,0 +1,37 @@
+package browser
+
+import (
+	"context"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	b "github.com/teamnsrg/mida/base"
+	"io/ioutil"
+)
+
+// installInitScripts registers the init scripts for a task, so they run in every frame before any of the page's
+// own scripts. Along with them, we add the binding through which they report records back to us. The binding
+// must come first, so it is already available when the scripts run. It must be run before navigation.
+func installInitScripts(cxt context.Context, st *b.SanitizedTask) error {
+	if len(st.InitScripts) == 0 {
+		return nil
+	}
+
+	err := runtime.AddBinding(b.DefaultInstrumentationBinding).Do(cxt)
+	if err != nil {
+		return err
+	}
+
+	for _, script := range st.InitScripts {
+		source, err := ioutil.ReadFile(script)
+		if err != nil {
+			return err
+		}
+
+		_, err = page.AddScriptToEvaluateOnNewDocument(string(source)).Do(cxt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package browser
+
+import (
+	"context"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/cdproto/target"
+	"github.com/teamnsrg/chromedp"
+	b "github.com/teamnsrg/mida/base"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// startPopup reserves a slot for a new popup, returning its index, or false if we are
+// already following as many popups as we are allowed to (or have stopped following them)
+func startPopup(devtoolsState *DTState, maxPopups int) (int, bool) {
+	devtoolsState.Lock()
+	defer devtoolsState.Unlock()
+
+	if devtoolsState.popupsStopped || devtoolsState.numPopups >= maxPopups {
+		return 0, false
+	}
+
+	index := devtoolsState.numPopups
+	devtoolsState.numPopups += 1
+	devtoolsState.popupWG.Add(1)
+
+	return index, true
+}
+
+// stopPopups signals each popup we are following to finish up, and waits for them to do so.
+// It must be called before the browser is closed.
+func stopPopups(devtoolsState *DTState) {
+	devtoolsState.Lock()
+	devtoolsState.popupsStopped = true
+	close(devtoolsState.popupStop)
+	devtoolsState.Unlock()
+
+	devtoolsState.popupWG.Wait()
+}
+
+// followPopup attaches to a popup opened by the page, which the browser is holding for us, and records its network
+// traffic with the same event handlers we use for the page itself. Request rules, filter list blocking, replay,
+// emulation, header overrides and init scripts apply to the popup as they do to the page, and resources the popup
+// loads go into the same resources directory.
+// Once the visit ends, it gathers the navigation history and a screenshot of the popup and adds the popup to the
+// raw result as a child result.
+func followPopup(browserContext context.Context, info *target.Info, sessionID target.SessionID, index int, rawResult *b.RawResult, devtoolsState *DTState) {
+	defer devtoolsState.popupWG.Done()
+
+	tw := rawResult.TaskSummary.TaskWrapper
+	popup := &b.PopupRawResult{
+		Index:         index,
+		TargetID:      info.TargetID.String(),
+		OpenerID:      info.OpenerID.String(),
+		OpenerFrameID: info.OpenerFrameID.String(),
+		InitialURL:    info.URL,
+		Opened:        time.Now(),
+		NavHistory:    make([]page.NavigationEntry, 0),
+	}
+
+	// The popup gets its own raw result, so the event handlers keep its data separate from the page's
+	popupResult := &b.RawResult{
+		TaskSummary: b.TaskSummary{
+			TaskWrapper: tw,
+		},
+		DevTools: b.DevToolsRawData{
+			Network: b.DevToolsNetworkRawData{
+				RequestWillBeSent: make(map[string][]*network.EventRequestWillBeSent),
+				ResponseReceived:  make(map[string]*network.EventResponseReceived),
+				LoadingFinished:   make(map[string]*network.EventLoadingFinished),
+
+				EventSourceMessageReceived: make(map[string][]*network.EventEventSourceMessageReceived),
+
+				FilterMatches: make(map[string]*b.DTFilterMatch),
+				FilterBlocked: make(map[string]bool),
+			},
+		},
+	}
+	popupState := &DTState{
+		mainFrameLoaderId: info.TargetID.String(), // The main frame of a page shares its ID
+		filters:           devtoolsState.filters,
+		replay:            devtoolsState.replay,
+	}
+	if devtoolsState.replay != nil {
+		popupResult.TaskSummary.ReplayData = &b.ReplayMetadata{}
+	}
+
+	popupContext, popupCancel := chromedp.NewContext(browserContext, chromedp.WithTargetID(info.TargetID))
+	ec := openEventChannels()
+	var eventHandlerWG sync.WaitGroup
+	eventHandlerWG.Add(7)
+	go FetchRequestPaused(ec.requestPausedChan, popupResult, popupState, &eventHandlerWG, popupContext)
+	go FetchAuthRequired(ec.authRequiredChan, popupResult, &eventHandlerWG, popupContext)
+	go NetworkRequestWillBeSent(ec.requestWillBeSentChan, popupResult, popupState, &eventHandlerWG, popupContext)
+	go NetworkResponseReceived(ec.responseReceivedChan, popupResult, &eventHandlerWG, popupContext)
+	go NetworkLoadingFinished(ec.loadingFinishedChan, popupResult, &eventHandlerWG, popupContext, tw.Log)
+	go PageJavaScriptDialogOpening(ec.javascriptDialogOpeningChan, &eventHandlerWG, popupContext, tw.Log)
+	go RuntimeBindingCalled(ec.bindingCalledChan, popupResult, &eventHandlerWG, popupContext)
+
+	chromedp.ListenTarget(popupContext, func(ev interface{}) {
+		switch ev.(type) {
+		case *fetch.EventRequestPaused:
+			ec.requestPausedChan <- ev.(*fetch.EventRequestPaused)
+		case *fetch.EventAuthRequired:
+			ec.authRequiredChan <- ev.(*fetch.EventAuthRequired)
+		case *network.EventRequestWillBeSent:
+			ec.requestWillBeSentChan <- ev.(*network.EventRequestWillBeSent)
+		case *network.EventResponseReceived:
+			ec.responseReceivedChan <- ev.(*network.EventResponseReceived)
+		case *network.EventLoadingFinished:
+			ec.loadingFinishedChan <- ev.(*network.EventLoadingFinished)
+		case *page.EventJavascriptDialogOpening:
+			ec.javascriptDialogOpeningChan <- ev.(*page.EventJavascriptDialogOpening)
+		case *runtime.EventBindingCalled:
+			ec.bindingCalledChan <- ev.(*runtime.EventBindingCalled)
+		}
+	})
+
+	// The user agent recorded for the page is the one it sent, so we pass it on as the default for the popup
+	rawResult.Lock()
+	userAgent := rawResult.TaskSummary.CrawlerInfo.UserAgent
+	rawResult.Unlock()
+
+	err := chromedp.Run(popupContext, chromedp.ActionFunc(func(cxt context.Context) error {
+		err := page.Enable().Do(cxt)
+		if err != nil {
+			return err
+		}
+
+		err = network.Enable().Do(cxt)
+		if err != nil {
+			return err
+		}
+
+		// The popup should look like the same browser as the page which opened it
+		_, err = prepareTarget(cxt, tw, popupState, userAgent)
+		if err != nil {
+			return err
+		}
+
+		// In case the popup is waiting on any session, not just the one which held it when it opened
+		return runtime.RunIfWaitingForDebugger().Do(cxt)
+	}))
+
+	// Now that we are listening (or have failed to), let the popup go ahead
+	releaseErr := releaseTarget(browserContext, sessionID)
+	if releaseErr != nil {
+		tw.Log.Warn("failed to release popup " + info.URL + ": " + releaseErr.Error())
+	}
+
+	if err != nil {
+		tw.Log.Warn("failed to attach to popup " + info.URL + ": " + err.Error())
+	} else {
+		tw.Log.Debug("following popup " + info.URL)
+
+		// Keep recording until the visit ends or the popup closes
+		select {
+		case <-devtoolsState.popupStop:
+		case <-popupContext.Done():
+		}
+
+		finishContext, finishCancel := context.WithTimeout(popupContext, 10*time.Second)
+		err = chromedp.Run(finishContext, chromedp.ActionFunc(func(cxt context.Context) error {
+			_, entries, err := page.GetNavigationHistory().Do(cxt)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				popup.NavHistory = append(popup.NavHistory, *entry)
+			}
+			return nil
+		}))
+		if err != nil {
+			tw.Log.Warn("failed to get navigation history of popup: " + err.Error())
+		}
+
+		if *tw.SanitizedTask.DS.Screenshot {
+			popup.Screenshot, err = capturePopupScreenshot(finishContext, tw, index)
+			if err != nil {
+				tw.Log.Warn("failed to capture screenshot of popup: " + err.Error())
+			}
+		}
+		finishCancel()
+	}
+
+	popupCancel()
+	eventHandlerWG.Wait()
+
+	popupResult.Lock()
+	popup.Network = popupResult.DevTools.Network
+	ruleHits := popupResult.DevTools.RequestRuleHits
+	replayData := popupResult.TaskSummary.ReplayData
+	instrumentation := popupResult.DevTools.Instrumentation
+	popupResult.Unlock()
+
+	rawResult.Lock()
+	rawResult.DevTools.Popups = append(rawResult.DevTools.Popups, popup)
+	rawResult.DevTools.RequestRuleHits = append(rawResult.DevTools.RequestRuleHits, ruleHits...)
+	for _, record := range instrumentation {
+		if len(rawResult.DevTools.Instrumentation) >= b.DefaultMaxInstrumentationRecords {
+			break
+		}
+		rawResult.DevTools.Instrumentation = append(rawResult.DevTools.Instrumentation, record)
+	}
+	if replayData != nil && rawResult.TaskSummary.ReplayData != nil {
+		rawResult.TaskSummary.ReplayData.NumFulfilled += replayData.NumFulfilled
+		rawResult.TaskSummary.ReplayData.Misses = append(rawResult.TaskSummary.ReplayData.Misses, replayData.Misses...)
+	}
+	rawResult.Unlock()
+}
+
+// releaseTarget lets a target which the browser is holding for us go ahead, by detaching the session
+// which was attached to it when it opened
+func releaseTarget(cxt context.Context, sessionID target.SessionID) error {
+	return target.DetachFromTarget().WithSessionID(sessionID).
+		Do(cdp.WithExecutor(cxt, chromedp.FromContext(cxt).Browser))
+}
+
+// capturePopupScreenshot takes a screenshot of a popup, storing it in the popup's subdirectory of the temp directory
+func capturePopupScreenshot(cxt context.Context, tw *b.TaskWrapper, index int) (string, error) {
+	data, err := captureScreenshot(cxt, &tw.SanitizedTask.DS)
+	if err != nil {
+		return "", err
+	}
+
+	popupDir := path.Join(tw.TempDir, b.DefaultPopupSubdir, strconv.Itoa(index))
+	err = os.MkdirAll(popupDir, 0744)
+	if err != nil {
+		return "", err
+	}
+
+	screenshotFile := path.Join(popupDir, "screenshot."+*tw.SanitizedTask.DS.ScreenshotFormat)
+	err = ioutil.WriteFile(screenshotFile, data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return screenshotFile, nil
+}
@@ -2,17 +2,23 @@ package browser
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/dom"
-	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/domdebugger"
+	"github.com/chromedp/cdproto/domsnapshot"
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
 	"github.com/sirupsen/logrus"
 	"github.com/teamnsrg/chromedp"
 	b "github.com/teamnsrg/mida/base"
 	"github.com/teamnsrg/mida/log"
 	"io/ioutil"
 	"math/rand"
+	"os"
 	"path"
 	"strconv"
 	"sync"
@@ -35,7 +41,7 @@ func postLoadActions(cxt context.Context, tw *b.TaskWrapper, rawResult *b.RawRes
 	// Enable request interception to block navigation (if specified)
 	if *tw.SanitizedTask.IS.LockNavigation {
 		err := chromedp.Run(cxt, chromedp.ActionFunc(func(cxt context.Context) error {
-			err := fetch.Enable().Do(cxt)
+			err := enableFetch(cxt, tw)
 			if err != nil {
 				return err
 			}
@@ -52,10 +58,10 @@ func postLoadActions(cxt context.Context, tw *b.TaskWrapper, rawResult *b.RawRes
 
 	}
 
-	// Capture screenshot
+	// Capture screenshot(s)
 	if *tw.SanitizedTask.DS.Screenshot {
 		individualActionsWG.Add(1)
-		go captureScreenshot(cxt, path.Join(tw.TempDir, b.DefaultScreenshotFileName), tw.Log, &individualActionsWG, tw.SanitizedTask.URL)
+		go captureScreenshots(cxt, tw, &individualActionsWG)
 	}
 
 	// Capture cookies set so far
@@ -70,6 +76,30 @@ func postLoadActions(cxt context.Context, tw *b.TaskWrapper, rawResult *b.RawRes
 		go getDOM(cxt, tw.Log, rawResult, &individualActionsWG)
 	}
 
+	// Capture an MHTML snapshot of the page
+	if *tw.SanitizedTask.DS.MHTML {
+		individualActionsWG.Add(1)
+		go getMHTML(cxt, tw.Log, rawResult, &individualActionsWG)
+	}
+
+	// Capture a DOM snapshot, with layout information
+	if *tw.SanitizedTask.DS.DOMSnapshot {
+		individualActionsWG.Add(1)
+		go getDOMSnapshot(cxt, tw.Log, *tw.SanitizedTask.DS.DOMSnapshotStyles, rawResult, &individualActionsWG)
+	}
+
+	// Evaluate the post-load scripts for the task
+	if len(tw.SanitizedTask.PostLoadScripts) > 0 {
+		individualActionsWG.Add(1)
+		go runPostLoadScripts(cxt, tw, rawResult, &individualActionsWG)
+	}
+
+	// Trigger the event listeners present on the page
+	if *tw.SanitizedTask.IS.TriggerEventListeners {
+		individualActionsWG.Add(1)
+		go triggerEventListeners(cxt, tw.Log, rawResult, &individualActionsWG)
+	}
+
 	if *tw.SanitizedTask.IS.BasicInteraction {
 		individualActionsWG.Add(1)
 		go basicInteraction(cxt, tw.Log, &individualActionsWG)
@@ -136,31 +166,267 @@ func runGremlinsJS(cxt context.Context, taskLog *logrus.Logger, wg *sync.WaitGro
 	return
 }
 
-// captureScreenshot uses an existing browser context to capture a screenshot, logging any error to both
-// the global MIDA log and the task-specific log
-func captureScreenshot(cxt context.Context, outputPath string, taskLog *logrus.Logger, wg *sync.WaitGroup, Url string) {
-	var data []byte
-	var err error
-	err = chromedp.Run(cxt, chromedp.ActionFunc(func(cxt context.Context) error {
-		data, err = page.CaptureScreenshot().Do(cxt)
-		if err != nil {
-			log.Log.WithField("URL", Url).Warn(err)
-			return err
+// triggerEventListeners enumerates the event listeners attached to the window and to every node in the
+// document (via DOMDebugger.getEventListeners), then dispatches a synthetic event for each one. After each
+// event, we wait briefly so we can attribute any new network requests to the listener that triggered them.
+// This continues until we have triggered every listener, or the context is canceled.
+func triggerEventListeners(cxt context.Context, taskLog *logrus.Logger, rawResult *b.RawResult, wg *sync.WaitGroup) {
+	const objectGroup = "mida-event-listeners"
+	const dispatchFunction = `function(type) { this.dispatchEvent(new Event(type, {bubbles: true, cancelable: true})); }`
+
+	listeners := make([]*b.DTEventListener, 0)
+	err := chromedp.Run(cxt, chromedp.ActionFunc(func(cxt context.Context) error {
+		// Listeners on the document subtree come back with the backend node ID they are attached to,
+		// while listeners on the window do not
+		for _, target := range []string{"window", "document"} {
+			obj, exp, err := runtime.Evaluate(target).WithObjectGroup(objectGroup).Do(cxt)
+			if err != nil {
+				return err
+			} else if exp != nil {
+				return errors.New("failed to get " + target + " object: " + exp.Text)
+			}
+
+			found, err := domdebugger.GetEventListeners(obj.ObjectID).WithDepth(-1).WithPierce(true).Do(cxt)
+			if err != nil {
+				return err
+			}
+
+			for _, l := range found {
+				if target == "window" && l.BackendNodeID != 0 {
+					// We will pick this one up when we query the document
+					continue
+				}
+				listeners = append(listeners, &b.DTEventListener{
+					Target:        target,
+					BackendNodeID: l.BackendNodeID,
+					Type:          l.Type,
+					ScriptID:      l.ScriptID,
+					LineNumber:    l.LineNumber,
+					ColumnNumber:  l.ColumnNumber,
+					Requests:      make([]string, 0),
+				})
+			}
 		}
 		return nil
 	}))
 	if err != nil {
-		taskLog.Warn("error capturing screenshot: " + err.Error())
+		taskLog.Warn("failed to enumerate event listeners: " + err.Error())
+		wg.Done()
+		return
+	}
+	taskLog.Debugf("found %d event listeners to trigger", len(listeners))
+
+	// Several listeners for the same event may be attached to the same target, but a single
+	// event dispatched to that target will fire all of them, so we group them together
+	type dispatchKey struct {
+		node      cdp.BackendNodeID
+		eventType string
+	}
+	var order []dispatchKey
+	groups := make(map[dispatchKey][]*b.DTEventListener)
+	for _, l := range listeners {
+		k := dispatchKey{node: l.BackendNodeID, eventType: l.Type}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], l)
+	}
+
+	nodeNames := make(map[cdp.BackendNodeID]string)
+	for _, k := range order {
+		if cxt.Err() != nil {
+			break
+		}
+
+		before := requestIDs(rawResult)
+		var target string
+		err = chromedp.Run(cxt, chromedp.ActionFunc(func(cxt context.Context) error {
+			var obj *runtime.RemoteObject
+			var err error
+			if k.node == 0 {
+				target = "window"
+				var exp *runtime.ExceptionDetails
+				obj, exp, err = runtime.Evaluate("window").WithObjectGroup(objectGroup).Do(cxt)
+				if err == nil && exp != nil {
+					err = errors.New(exp.Text)
+				}
+			} else {
+				if _, ok := nodeNames[k.node]; !ok {
+					node, err := dom.DescribeNode().WithBackendNodeID(k.node).Do(cxt)
+					if err == nil {
+						nodeNames[k.node] = node.NodeName
+					}
+				}
+				target = nodeNames[k.node]
+				obj, err = dom.ResolveNode().WithBackendNodeID(k.node).WithObjectGroup(objectGroup).Do(cxt)
+			}
+			if err != nil {
+				return err
+			}
+
+			arg, err := json.Marshal(k.eventType)
+			if err != nil {
+				return err
+			}
+			_, exp, err := runtime.CallFunctionOn(dispatchFunction).WithObjectID(obj.ObjectID).
+				WithArguments([]*runtime.CallArgument{{Value: arg}}).Do(cxt)
+			if err != nil {
+				return err
+			} else if exp != nil {
+				return errors.New(exp.Text)
+			}
+
+			return cxtSleep(cxt, b.DefaultEventListenerSettleTime*time.Millisecond)
+		}))
+
+		var triggered []string
+		for id := range requestIDs(rawResult) {
+			if _, ok := before[id]; !ok {
+				triggered = append(triggered, id)
+			}
+		}
+
+		for _, l := range groups[k] {
+			if target != "" {
+				l.Target = target
+			}
+			if err != nil {
+				l.Error = err.Error()
+			} else {
+				l.Dispatched = true
+			}
+			l.Requests = append(l.Requests, triggered...)
+		}
+	}
+
+	// Best effort only, since the browser may already be closing
+	_ = chromedp.Run(cxt, runtime.ReleaseObjectGroup(objectGroup))
+
+	rawResult.Lock()
+	rawResult.DevTools.EventListeners = listeners
+	rawResult.Unlock()
+
+	wg.Done()
+}
+
+// requestIDs returns the set of IDs for all network requests we have seen so far
+func requestIDs(rawResult *b.RawResult) map[string]struct{} {
+	rawResult.Lock()
+	ids := make(map[string]struct{}, len(rawResult.DevTools.Network.RequestWillBeSent))
+	for id := range rawResult.DevTools.Network.RequestWillBeSent {
+		ids[id] = struct{}{}
+	}
+	rawResult.Unlock()
+
+	return ids
+}
+
+// captureScreenshots takes a screenshot right after the load event and, if the task specifies an interval,
+// keeps taking them until the browser closes. With the default settings, we write a single screenshot.png to
+// the temp directory. Otherwise, screenshots are written to the screenshots directory in the temp directory,
+// along with an index recording when each one was taken.
+func captureScreenshots(cxt context.Context, tw *b.TaskWrapper, wg *sync.WaitGroup) {
+	ds := tw.SanitizedTask.DS
+	if !b.ScreenshotSeries(&ds) {
+		data, err := captureScreenshot(cxt, &ds)
+		if err != nil {
+			tw.Log.Warn("error capturing screenshot: " + err.Error())
+			log.Log.WithField("URL", tw.SanitizedTask.URL).Warn(err)
+		} else {
+			err = ioutil.WriteFile(path.Join(tw.TempDir, b.DefaultScreenshotFileName), data, 0644)
+			if err != nil {
+				tw.Log.Warn("error writing screenshot to file: " + err.Error())
+			}
+		}
+		wg.Done()
+		return
+	}
+
+	screenshotDir := path.Join(tw.TempDir, b.DefaultScreenshotSubdir)
+	index := make([]b.ScreenshotIndexEntry, 0)
+
+	err := os.MkdirAll(screenshotDir, 0744)
+	if err != nil {
+		tw.Log.Error("failed to create screenshot subdir within temp directory: " + err.Error())
+		wg.Done()
+		return
+	}
+
+	for i := 0; ; i += 1 {
+		timestamp := time.Now()
+		data, err := captureScreenshot(cxt, &ds)
+		if err != nil {
+			tw.Log.Warn("error capturing screenshot: " + err.Error())
+			log.Log.WithField("URL", tw.SanitizedTask.URL).Warn(err)
+		} else {
+			fileName := fmt.Sprintf("%04d.%s", i, *ds.ScreenshotFormat)
+			err = ioutil.WriteFile(path.Join(screenshotDir, fileName), data, 0644)
+			if err != nil {
+				tw.Log.Warn("error writing screenshot to file: " + err.Error())
+			} else {
+				index = append(index, b.ScreenshotIndexEntry{
+					File:      fileName,
+					Timestamp: timestamp,
+					FullPage:  *ds.ScreenshotFullPage,
+				})
+			}
+		}
+
+		if *ds.ScreenshotInterval == 0 {
+			break
+		}
+		err = cxtSleep(cxt, time.Duration(*ds.ScreenshotInterval)*time.Second)
+		if err != nil {
+			// The browser has closed, so we are done
+			break
+		}
 	}
 
-	err = ioutil.WriteFile(outputPath, data, 0644)
+	data, err := json.Marshal(index)
 	if err != nil {
-		taskLog.Warn("error writing screenshot to file: " + err.Error())
+		tw.Log.Error("failed to marshal screenshot index: " + err.Error())
+	} else {
+		err = ioutil.WriteFile(path.Join(screenshotDir, b.DefaultScreenshotIndexFile), data, 0644)
+		if err != nil {
+			tw.Log.Error("failed to write screenshot index: " + err.Error())
+		}
 	}
 
 	wg.Done()
 }
 
+// captureScreenshot uses an existing browser context to capture a single screenshot in the format specified by
+// the data settings. For full-page screenshots, we size the clip using the layout metrics of the page.
+func captureScreenshot(cxt context.Context, ds *b.DataSettings) ([]byte, error) {
+	var data []byte
+	err := chromedp.Run(cxt, chromedp.ActionFunc(func(cxt context.Context) error {
+		params := page.CaptureScreenshot().WithFormat(page.CaptureScreenshotFormat(*ds.ScreenshotFormat))
+		if *ds.ScreenshotFormat != "png" {
+			params = params.WithQuality(int64(*ds.ScreenshotQuality))
+		}
+
+		if *ds.ScreenshotFullPage {
+			_, _, _, _, _, contentSize, err := page.GetLayoutMetrics().Do(cxt)
+			if err != nil {
+				return err
+			}
+			params = params.WithCaptureBeyondViewport(true).WithClip(&page.Viewport{
+				X:      0,
+				Y:      0,
+				Width:  contentSize.Width,
+				Height: contentSize.Height,
+				Scale:  1,
+			})
+		}
+
+		var err error
+		data, err = params.Do(cxt)
+		return err
+	}))
+
+	return data, err
+}
+
 // getCookies grabs all cookies from the browser
 func getCookies(cxt context.Context, taskLog *logrus.Logger, rawResult *b.RawResult, wg *sync.WaitGroup) {
 	var cookies []*network.Cookie
@@ -208,6 +474,71 @@ func getDOM(cxt context.Context, taskLog *logrus.Logger, rawResult *b.RawResult,
 	wg.Done()
 }
 
+// getMHTML captures a self-contained MHTML snapshot of the page
+func getMHTML(cxt context.Context, taskLog *logrus.Logger, rawResult *b.RawResult, wg *sync.WaitGroup) {
+	var data string
+	var err error
+	err = chromedp.Run(cxt, chromedp.ActionFunc(func(cxt context.Context) error {
+		data, err = page.CaptureSnapshot().WithFormat(page.CaptureSnapshotFormatMhtml).Do(cxt)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}))
+	if err != nil {
+		taskLog.Warn("failed to capture MHTML snapshot: " + err.Error())
+	} else {
+		rawResult.Lock()
+		rawResult.DevTools.MHTML = data
+		rawResult.Unlock()
+	}
+
+	wg.Done()
+}
+
+// runPostLoadScripts evaluates each of the post-load scripts for the task in turn, waiting for any promises
+// they return to settle, and records their results (or the exceptions they threw) in the task summary
+func runPostLoadScripts(cxt context.Context, tw *b.TaskWrapper, rawResult *b.RawResult, wg *sync.WaitGroup) {
+	results := make(map[string]*b.ScriptResult)
+	for _, script := range tw.SanitizedTask.PostLoadScripts {
+		result := new(b.ScriptResult)
+		err := chromedp.Run(cxt, chromedp.ActionFunc(func(cxt context.Context) error {
+			value, exceptionDetails, err := runtime.Evaluate(*script.Expression).
+				WithAwaitPromise(true).WithReturnByValue(true).Do(cxt)
+			if err != nil {
+				return err
+			}
+
+			if exceptionDetails != nil {
+				result.Exception = exceptionDetails.Text
+				if exceptionDetails.Exception != nil && exceptionDetails.Exception.Description != "" {
+					result.Exception = exceptionDetails.Exception.Description
+				}
+			} else if value != nil && len(value.Value) > 0 {
+				result.Result = json.RawMessage(value.Value)
+			} else {
+				// Expressions which evaluate to undefined have no value
+				result.Result = json.RawMessage("null")
+			}
+
+			return nil
+		}))
+		if err != nil {
+			tw.Log.Warn("failed to evaluate post-load script " + *script.Name + ": " + err.Error())
+			result.Error = err.Error()
+		}
+
+		results[*script.Name] = result
+	}
+
+	rawResult.Lock()
+	rawResult.TaskSummary.ScriptResults = results
+	rawResult.Unlock()
+
+	wg.Done()
+}
+
 // cxtSleep is just a wrapper around a sleep function to make it responsive
 // to context cancellations
 func cxtSleep(cxt context.Context, t time.Duration) error {
@@ -218,3 +549,32 @@ func cxtSleep(cxt context.Context, t time.Duration) error {
 		return nil
 	}
 }
+
+// getDOMSnapshot captures a flattened snapshot of the DOM (including frames), along with the layout
+// boxes, paint order and requested computed styles of each rendered node
+func getDOMSnapshot(cxt context.Context, taskLog *logrus.Logger, styles []string, rawResult *b.RawResult, wg *sync.WaitGroup) {
+	var documents []*domsnapshot.DocumentSnapshot
+	var strs []string
+	var err error
+	err = chromedp.Run(cxt, chromedp.ActionFunc(func(cxt context.Context) error {
+		documents, strs, err = domsnapshot.CaptureSnapshot(styles).
+			WithIncludePaintOrder(true).WithIncludeDOMRects(true).Do(cxt)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}))
+	if err != nil {
+		taskLog.Warn("failed to capture DOM snapshot: " + err.Error())
+	} else {
+		rawResult.Lock()
+		rawResult.DevTools.DOMSnapshot = &b.DevToolsDOMSnapshotRawData{
+			Documents: documents,
+			Strings:   strs,
+		}
+		rawResult.Unlock()
+	}
+
+	wg.Done()
+}
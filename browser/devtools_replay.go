@@ -0,0 +1,150 @@
+package browser
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	b "github.com/teamnsrg/mida/base"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Headers which no longer describe the bodies we stored, since the browser gave us decoded bodies
+var replayStrippedHeaders = []string{"content-encoding", "transfer-encoding", "content-length"}
+
+// replayResponse is a single recorded response, ready to be used to fulfill a request
+type replayResponse struct {
+	status     int64
+	statusText string
+	headers    []*fetch.HeaderEntry
+	bodyFile   string // Empty for redirects, which have no body
+}
+
+// replayArchive holds the responses recorded in an earlier results directory, keyed by request
+// method and URL. When the same request was made more than once, its responses are replayed in order.
+type replayArchive struct {
+	responses map[string][]*replayResponse
+	next      map[string]int
+	sync.Mutex
+}
+
+// loadReplayArchive reads the resource metadata (and locates the resource bodies) in an earlier results directory
+func loadReplayArchive(replayDir string) (*replayArchive, error) {
+	data, err := ioutil.ReadFile(path.Join(replayDir, b.DefaultResourceMetadataFile))
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make(map[string]b.DTResource)
+	err = json.Unmarshal(data, &resources)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &replayArchive{
+		responses: make(map[string][]*replayResponse),
+		next:      make(map[string]int),
+	}
+
+	for requestID, resource := range resources {
+		for i, req := range resource.Requests {
+			if req.Request == nil {
+				continue
+			}
+
+			// Each hop of a redirect chain gets its response from the hop that follows it
+			var resp *network.Response
+			bodyFile := ""
+			if i < len(resource.Requests)-1 {
+				resp = resource.Requests[i+1].RedirectResponse
+			} else if resource.Response != nil {
+				resp = resource.Response.Response
+				bodyFile = path.Join(replayDir, b.DefaultResourceSubdir, requestID)
+			}
+			if resp == nil {
+				continue
+			}
+
+			key := replayKey(req.Request.Method, req.Request.URL)
+			archive.responses[key] = append(archive.responses[key], &replayResponse{
+				status:     resp.Status,
+				statusText: resp.StatusText,
+				headers:    replayHeaders(resp.Headers),
+				bodyFile:   bodyFile,
+			})
+		}
+	}
+
+	return archive, nil
+}
+
+// lookup returns the next recorded response for a request, or nil if it has no recorded counterpart.
+// Once we run out of responses for a repeated request, we keep replaying the last one.
+func (ra *replayArchive) lookup(method string, url string) *replayResponse {
+	key := replayKey(method, url)
+
+	ra.Lock()
+	defer ra.Unlock()
+	responses, ok := ra.responses[key]
+	if !ok {
+		return nil
+	}
+
+	i := ra.next[key]
+	if i < len(responses)-1 {
+		ra.next[key] = i + 1
+	}
+
+	return responses[i]
+}
+
+// fulfill responds to a paused request using a recorded response
+func (rr *replayResponse) fulfill(cxt context.Context, requestID fetch.RequestID) error {
+	var body []byte
+	if rr.bodyFile != "" {
+		// A missing body just means the earlier crawl did not store it, so we replay an empty one
+		body, _ = ioutil.ReadFile(rr.bodyFile)
+	}
+
+	params := fetch.FulfillRequest(requestID, rr.status).WithResponseHeaders(rr.headers)
+	if rr.statusText != "" {
+		params = params.WithResponsePhrase(rr.statusText)
+	}
+	if len(body) > 0 {
+		params = params.WithBody(base64.StdEncoding.EncodeToString(body))
+	}
+
+	return params.Do(cxt)
+}
+
+func replayKey(method string, url string) string {
+	return method + " " + url
+}
+
+// replayHeaders converts stored response headers to the form expected by the Fetch domain
+func replayHeaders(headers network.Headers) []*fetch.HeaderEntry {
+	result := make([]*fetch.HeaderEntry, 0, len(headers))
+	for name, value := range headers {
+		stripped := false
+		for _, h := range replayStrippedHeaders {
+			if strings.EqualFold(name, h) {
+				stripped = true
+			}
+		}
+		if stripped {
+			continue
+		}
+
+		// Chromium joins repeated headers with newlines
+		for _, v := range strings.Split(fmt.Sprint(value), "\n") {
+			result = append(result, &fetch.HeaderEntry{Name: name, Value: v})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,89 @@
+package browser
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	b "github.com/teamnsrg/mida/base"
+	"io/ioutil"
+	"strings"
+)
+
+// matchRequestRule returns the first of the given rules which matches a paused request, or nil if none match
+func matchRequestRule(rules []b.SanitizedRequestRule, ev *fetch.EventRequestPaused) *b.SanitizedRequestRule {
+	for i, rule := range rules {
+		if !rule.URLPattern.MatchString(ev.Request.URL + ev.Request.URLFragment) {
+			continue
+		}
+
+		if len(rule.ResourceTypes) > 0 {
+			typeMatch := false
+			for _, rt := range rule.ResourceTypes {
+				if strings.EqualFold(rt, ev.ResourceType.String()) {
+					typeMatch = true
+				}
+			}
+			if !typeMatch {
+				continue
+			}
+		}
+
+		return &rules[i]
+	}
+
+	return nil
+}
+
+// applyRequestRule takes the action specified by a rule on a paused request
+func applyRequestRule(cxt context.Context, rule *b.SanitizedRequestRule, ev *fetch.EventRequestPaused) error {
+	switch rule.Action {
+	case b.RequestRuleBlock:
+		return fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient).Do(cxt)
+
+	case b.RequestRuleRewriteHeaders:
+		headers := make(map[string]string)
+		for k, v := range ev.Request.Headers {
+			headers[k] = fmt.Sprint(v)
+		}
+		for k, v := range rule.Headers {
+			// Header names are case-insensitive, so drop any existing header with the same name
+			for existing := range headers {
+				if strings.EqualFold(existing, k) {
+					delete(headers, existing)
+				}
+			}
+			if v != "" {
+				headers[k] = v
+			}
+		}
+
+		return fetch.ContinueRequest(ev.RequestID).WithHeaders(headerEntries(headers)).Do(cxt)
+
+	case b.RequestRuleFulfill:
+		body, err := ioutil.ReadFile(rule.FulfillFile)
+		if err != nil {
+			// Don't leave the request hanging just because we couldn't read the file
+			_ = fetch.FailRequest(ev.RequestID, network.ErrorReasonFailed).Do(cxt)
+			return err
+		}
+
+		return fetch.FulfillRequest(ev.RequestID, int64(rule.FulfillStatus)).
+			WithResponseHeaders(headerEntries(rule.FulfillHeaders)).
+			WithBody(base64.StdEncoding.EncodeToString(body)).Do(cxt)
+
+	default:
+		return fetch.ContinueRequest(ev.RequestID).Do(cxt)
+	}
+}
+
+// headerEntries converts a map of headers into the form expected by the Fetch domain
+func headerEntries(headers map[string]string) []*fetch.HeaderEntry {
+	result := make([]*fetch.HeaderEntry, 0, len(headers))
+	for k, v := range headers {
+		result = append(result, &fetch.HeaderEntry{Name: k, Value: v})
+	}
+
+	return result
+}
@@ -0,0 +1,64 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	b "github.com/teamnsrg/mida/base"
+)
+
+// seedStorage installs the seed cookies and localStorage items for a task. It must be run before navigation.
+// localStorage can only be written from a document of the right origin, so we install a script which sets
+// the items as each new document is created. It should be removed (see removeSeedScript) once the page loads,
+// so the items do not overwrite any changes the page makes to them.
+func seedStorage(cxt context.Context, st *b.SanitizedTask, devtoolsState *DTState) error {
+	if len(st.SeedCookies) > 0 {
+		err := network.SetCookies(st.SeedCookies).Do(cxt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(st.SeedLocalStorage) > 0 {
+		items, err := json.Marshal(st.SeedLocalStorage)
+		if err != nil {
+			return err
+		}
+
+		script := `((seed) => {
+			try {
+				const items = seed[window.location.origin];
+				if (items) {
+					for (const key of Object.keys(items)) {
+						window.localStorage.setItem(key, items[key]);
+					}
+				}
+			} catch (e) {}
+		})(` + string(items) + `);`
+		identifier, err := page.AddScriptToEvaluateOnNewDocument(script).Do(cxt)
+		if err != nil {
+			return err
+		}
+
+		devtoolsState.Lock()
+		devtoolsState.seedScript = identifier
+		devtoolsState.Unlock()
+	}
+
+	return nil
+}
+
+// removeSeedScript stops seeding localStorage items into new documents
+func removeSeedScript(cxt context.Context, devtoolsState *DTState) error {
+	devtoolsState.Lock()
+	identifier := devtoolsState.seedScript
+	devtoolsState.seedScript = ""
+	devtoolsState.Unlock()
+
+	if identifier == "" {
+		return nil
+	}
+
+	return page.RemoveScriptToEvaluateOnNewDocument(identifier).Do(cxt)
+}
@@ -0,0 +1,125 @@
+package browser
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/sirupsen/logrus"
+	"github.com/teamnsrg/chromedp"
+	b "github.com/teamnsrg/mida/base"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestProxyAuthResponse checks that proxy challenges are answered with our credentials once per request,
+// and that everything else is left to the browser
+func TestProxyAuthResponse(t *testing.T) {
+	t.Parallel()
+
+	proxy := &b.SanitizedProxy{URL: "http://127.0.0.1:8080", Username: "user", Password: "secret"}
+	answered := make(map[fetch.RequestID]bool)
+	ev := &fetch.EventAuthRequired{
+		RequestID:     "1",
+		Request:       &network.Request{URL: "http://example.com/"},
+		AuthChallenge: &fetch.AuthChallenge{Source: fetch.AuthChallengeSourceProxy, Origin: "http://127.0.0.1:8080"},
+	}
+
+	resp := proxyAuthResponse(ev, proxy, answered)
+	if resp.Response != fetch.AuthChallengeResponseResponseProvideCredentials || resp.Username != "user" || resp.Password != "secret" {
+		t.Fatalf("expected credentials for first proxy challenge, got %+v", resp)
+	}
+	resp = proxyAuthResponse(ev, proxy, answered)
+	if resp.Response != fetch.AuthChallengeResponseResponseCancelAuth {
+		t.Fatalf("expected rejected credentials to be canceled, got %+v", resp)
+	}
+
+	ev.AuthChallenge.Source = fetch.AuthChallengeSourceServer
+	ev.RequestID = "2"
+	resp = proxyAuthResponse(ev, proxy, answered)
+	if resp.Response != fetch.AuthChallengeResponseResponseDefault || resp.Username != "" {
+		t.Fatalf("expected server challenge to be left to the browser, got %+v", resp)
+	}
+}
+
+// TestProxyAuthentication visits a page through a local proxy which requires credentials, making sure the
+// browser gets through using those from the task. It needs a browser, so it is skipped when none is installed.
+func TestProxyAuthentication(t *testing.T) {
+	binary := ""
+	for _, name := range []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser"} {
+		p, err := exec.LookPath(name)
+		if err == nil {
+			binary = p
+			break
+		}
+	}
+	if binary == "" {
+		t.Skip("no browser available")
+	}
+
+	var authorized int32
+	expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != expected {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="mida"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		atomic.AddInt32(&authorized, 1)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><body>proxied</body></html>"))
+	}))
+	defer proxy.Close()
+
+	tw := &b.TaskWrapper{
+		SanitizedTask: b.SanitizedTask{
+			Proxy: &b.SanitizedProxy{URL: proxy.URL, Username: "user", Password: "secret"},
+		},
+		Log: logrus.New(),
+	}
+	rawResult := &b.RawResult{TaskSummary: b.TaskSummary{TaskWrapper: tw}}
+
+	opts := append(chromedp.DefaultExecAllocatorOptions[:], chromedp.ExecPath(binary), chromedp.NoSandbox,
+		chromedp.ProxyServer(proxy.URL))
+	allocContext, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
+	defer allocCancel()
+	browserContext, browserCancel := chromedp.NewContext(allocContext)
+	defer browserCancel()
+
+	ec := openEventChannels()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go FetchAuthRequired(ec.authRequiredChan, rawResult, &wg, browserContext)
+	chromedp.ListenTarget(browserContext, func(ev interface{}) {
+		switch ev.(type) {
+		case *fetch.EventAuthRequired:
+			ec.authRequiredChan <- ev.(*fetch.EventAuthRequired)
+		case *fetch.EventRequestPaused:
+			// Requests are paused as well once Fetch is enabled, so just let them through
+			id := ev.(*fetch.EventRequestPaused).RequestID
+			go func() {
+				_ = chromedp.Run(browserContext, fetch.ContinueRequest(id))
+			}()
+		}
+	})
+
+	visitContext, visitCancel := context.WithTimeout(browserContext, 30*time.Second)
+	defer visitCancel()
+	err := chromedp.Run(visitContext, chromedp.ActionFunc(func(cxt context.Context) error {
+		return enableFetch(cxt, tw)
+	}), chromedp.Navigate("http://mida.test/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&authorized) == 0 {
+		t.Fatal("no request reached the proxy with our credentials")
+	}
+
+	browserCancel()
+	wg.Wait()
+}
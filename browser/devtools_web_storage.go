@@ -0,0 +1,202 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/chromedp/cdproto/domstorage"
+	"github.com/chromedp/cdproto/indexeddb"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/sirupsen/logrus"
+	b "github.com/teamnsrg/mida/base"
+	"strings"
+	"unicode/utf8"
+)
+
+// getWebStorage gathers the localStorage, sessionStorage and IndexedDB contents of every origin
+// present in the frame tree, keyed by origin. Failures for an individual origin are logged and skipped.
+func getWebStorage(cxt context.Context, taskLog *logrus.Logger) (map[string]*b.DTOriginStorage, error) {
+	frameTree, err := page.GetFrameTree().Do(cxt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = domstorage.Enable().Do(cxt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexeddb.Enable().Do(cxt)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*b.DTOriginStorage)
+	for _, origin := range frameTreeOrigins(frameTree, nil) {
+		if _, ok := result[origin]; ok {
+			continue
+		}
+
+		originStorage := &b.DTOriginStorage{
+			IndexedDB: make([]*b.DTIndexedDBDatabase, 0),
+		}
+		originStorage.LocalStorage, originStorage.LocalStorageTruncated, err = getDOMStorage(cxt, origin, true)
+		if err != nil {
+			taskLog.Debug("failed to get localStorage for " + origin + ": " + err.Error())
+		}
+		originStorage.SessionStorage, originStorage.SessionStorageTruncated, err = getDOMStorage(cxt, origin, false)
+		if err != nil {
+			taskLog.Debug("failed to get sessionStorage for " + origin + ": " + err.Error())
+		}
+
+		dbNames, err := indexeddb.RequestDatabaseNames().WithSecurityOrigin(origin).Do(cxt)
+		if err != nil {
+			taskLog.Debug("failed to get IndexedDB databases for " + origin + ": " + err.Error())
+		}
+		for _, name := range dbNames {
+			db, err := getIndexedDBDatabase(cxt, origin, name)
+			if err != nil {
+				taskLog.Debug("failed to get IndexedDB database " + name + " for " + origin + ": " + err.Error())
+				continue
+			}
+			originStorage.IndexedDB = append(originStorage.IndexedDB, db)
+		}
+
+		result[origin] = originStorage
+	}
+
+	return result, nil
+}
+
+// frameTreeOrigins lists the security origins of each frame in the tree. Opaque origins
+// (e.g., sandboxed frames and about:blank) have no storage of their own, so they are skipped.
+func frameTreeOrigins(ft *page.FrameTree, origins []string) []string {
+	if ft == nil {
+		return origins
+	}
+
+	if ft.Frame != nil && strings.HasPrefix(ft.Frame.SecurityOrigin, "http") {
+		origins = append(origins, ft.Frame.SecurityOrigin)
+	}
+	for _, child := range ft.ChildFrames {
+		origins = frameTreeOrigins(child, origins)
+	}
+
+	return origins
+}
+
+// getDOMStorage returns the items in either the localStorage or sessionStorage area of an origin,
+// along with whether any items were dropped because of the cap
+func getDOMStorage(cxt context.Context, origin string, isLocalStorage bool) (map[string]string, bool, error) {
+	result := make(map[string]string)
+	items, err := domstorage.GetDOMStorageItems(&domstorage.StorageID{
+		SecurityOrigin: origin,
+		IsLocalStorage: isLocalStorage,
+	}).Do(cxt)
+	if err != nil {
+		return result, false, err
+	}
+
+	for i, item := range items {
+		if i >= b.DefaultWebStorageMaxItems {
+			return result, true, nil
+		}
+		if len(item) != 2 {
+			continue
+		}
+		result[item[0]] = truncateStorageValue(item[1])
+	}
+
+	return result, false, nil
+}
+
+// getIndexedDBDatabase returns the object stores of an IndexedDB database, along with their entries (up to the cap)
+func getIndexedDBDatabase(cxt context.Context, origin string, name string) (*b.DTIndexedDBDatabase, error) {
+	db, err := indexeddb.RequestDatabase(name).WithSecurityOrigin(origin).Do(cxt)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &b.DTIndexedDBDatabase{
+		Name:         db.Name,
+		Version:      db.Version,
+		ObjectStores: make([]*b.DTIndexedDBObjectStore, 0, len(db.ObjectStores)),
+	}
+	for _, store := range db.ObjectStores {
+		dtStore := &b.DTIndexedDBObjectStore{
+			Name:          store.Name,
+			KeyPath:       store.KeyPath,
+			AutoIncrement: store.AutoIncrement,
+			Entries:       make([]*b.DTIndexedDBEntry, 0),
+		}
+		result.ObjectStores = append(result.ObjectStores, dtStore)
+
+		count, _, err := indexeddb.GetMetadata(name, store.Name).WithSecurityOrigin(origin).Do(cxt)
+		if err == nil {
+			dtStore.NumEntries = int(count)
+		}
+
+		entries, hasMore, err := indexeddb.RequestData(name, store.Name, "", 0,
+			b.DefaultIndexedDBMaxEntries).WithSecurityOrigin(origin).Do(cxt)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			dtStore.Entries = append(dtStore.Entries, &b.DTIndexedDBEntry{
+				Key:        remoteObjectString(cxt, entry.Key),
+				PrimaryKey: remoteObjectString(cxt, entry.PrimaryKey),
+				Value:      remoteObjectString(cxt, entry.Value),
+			})
+		}
+		dtStore.Truncated = hasMore
+		if dtStore.NumEntries < len(dtStore.Entries) {
+			dtStore.NumEntries = len(dtStore.Entries)
+		}
+	}
+
+	return result, nil
+}
+
+// remoteObjectString gives a (truncated) string representation of a remote object: its value for
+// primitives, and its JSON serialization for objects. We fall back to the description for objects
+// which cannot be serialized (e.g., those containing cycles).
+func remoteObjectString(cxt context.Context, ro *runtime.RemoteObject) string {
+	if ro == nil {
+		return ""
+	}
+	if len(ro.Value) > 0 {
+		return truncateStorageValue(string(ro.Value))
+	}
+	if ro.UnserializableValue != "" {
+		return string(ro.UnserializableValue)
+	}
+	if ro.ObjectID != "" {
+		// Best effort only, since the object is of no further use to us
+		defer runtime.ReleaseObject(ro.ObjectID).Do(cxt)
+
+		result, exception, err := runtime.CallFunctionOn("function() { return JSON.stringify(this); }").
+			WithObjectID(ro.ObjectID).WithReturnByValue(true).Do(cxt)
+		if err == nil && exception == nil && result != nil && len(result.Value) > 0 {
+			var value string
+			err = json.Unmarshal(result.Value, &value)
+			if err == nil {
+				return truncateStorageValue(value)
+			}
+		}
+	}
+
+	return truncateStorageValue(ro.Description)
+}
+
+// truncateStorageValue caps the length of a stored value, without splitting a multi-byte character
+func truncateStorageValue(value string) string {
+	if len(value) <= b.DefaultWebStorageMaxValueLength {
+		return value
+	}
+
+	end := b.DefaultWebStorageMaxValueLength
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end -= 1
+	}
+	return value[:end]
+}
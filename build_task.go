@@ -135,6 +135,72 @@ func BuildCompressedTaskSet(cmd *cobra.Command, args []string) (*b.CompressedTas
 	if err != nil {
 		return nil, err
 	}
+	device, err := cmd.Flags().GetString("device")
+	if err != nil {
+		return nil, err
+	}
+	if device != "" {
+		ts.Browser.Emulation = &b.EmulationSettings{Device: &device}
+	}
+	userAgent, err := cmd.Flags().GetString("user-agent")
+	if err != nil {
+		return nil, err
+	}
+	if userAgent != "" {
+		ts.Browser.UserAgent = &userAgent
+	}
+	acceptLanguage, err := cmd.Flags().GetString("accept-language")
+	if err != nil {
+		return nil, err
+	}
+	if acceptLanguage != "" {
+		ts.Browser.AcceptLanguage = &acceptLanguage
+	}
+	extraHeaders, err := cmd.Flags().GetStringArray("header")
+	if err != nil {
+		return nil, err
+	}
+	if len(extraHeaders) > 0 {
+		headers := make(map[string]string)
+		for _, h := range extraHeaders {
+			parts := strings.SplitN(h, ":", 2)
+			if len(parts) != 2 {
+				return nil, errors.New("invalid header (expected \"Name: Value\"): " + h)
+			}
+			headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		ts.Browser.ExtraHTTPHeaders = &headers
+	}
+	proxyURL, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != "" {
+		ts.Browser.Proxy = &b.ProxySettings{URL: &proxyURL}
+	}
+	initScripts, err := cmd.Flags().GetStringSlice("init-scripts")
+	if err != nil {
+		return nil, err
+	}
+	if len(initScripts) > 0 {
+		ts.Browser.InitScripts = &initScripts
+	}
+	postLoadScripts, err := cmd.Flags().GetStringArray("post-load-script")
+	if err != nil {
+		return nil, err
+	}
+	if len(postLoadScripts) > 0 {
+		scripts := make([]b.PostLoadScript, 0, len(postLoadScripts))
+		for _, s := range postLoadScripts {
+			parts := strings.SplitN(s, "=", 2)
+			if len(parts) != 2 {
+				return nil, errors.New("invalid post-load script (expected \"name=expression\"): " + s)
+			}
+			name, expression := strings.TrimSpace(parts[0]), parts[1]
+			scripts = append(scripts, b.PostLoadScript{Name: &name, Expression: &expression})
+		}
+		ts.PostLoadScripts = &scripts
+	}
 
 	*ts.Browser.InteractionSettings.LockNavigation, err = cmd.Flags().GetBool("nav-lock")
 	if err != nil {
@@ -152,6 +218,14 @@ func BuildCompressedTaskSet(cmd *cobra.Command, args []string) (*b.CompressedTas
 	if err != nil {
 		return nil, err
 	}
+	*ts.Browser.InteractionSettings.FollowPopups, err = cmd.Flags().GetBool("follow-popups")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Browser.InteractionSettings.MaxPopups, err = cmd.Flags().GetInt("max-popups")
+	if err != nil {
+		return nil, err
+	}
 
 	// Shortcut to allow headless task with a shorter flag
 	headless, err := cmd.Flags().GetBool("headless")
@@ -162,6 +236,53 @@ func BuildCompressedTaskSet(cmd *cobra.Command, args []string) (*b.CompressedTas
 		*ts.Browser.AddBrowserFlags = append(*ts.Browser.AddBrowserFlags, "headless")
 	}
 
+	// Network conditions are only emulated if asked for, and explicit values override those of the profile
+	nc := new(b.NetworkConditions)
+	emulateNetwork := false
+	networkProfile, err := cmd.Flags().GetString("network-profile")
+	if err != nil {
+		return nil, err
+	}
+	if networkProfile != "" {
+		nc.Profile = &networkProfile
+		emulateNetwork = true
+	}
+	if cmd.Flags().Changed("latency") {
+		nc.Latency = new(float64)
+		*nc.Latency, err = cmd.Flags().GetFloat64("latency")
+		if err != nil {
+			return nil, err
+		}
+		emulateNetwork = true
+	}
+	if cmd.Flags().Changed("download-throughput") {
+		nc.DownloadThroughput = new(float64)
+		*nc.DownloadThroughput, err = cmd.Flags().GetFloat64("download-throughput")
+		if err != nil {
+			return nil, err
+		}
+		emulateNetwork = true
+	}
+	if cmd.Flags().Changed("upload-throughput") {
+		nc.UploadThroughput = new(float64)
+		*nc.UploadThroughput, err = cmd.Flags().GetFloat64("upload-throughput")
+		if err != nil {
+			return nil, err
+		}
+		emulateNetwork = true
+	}
+	if cmd.Flags().Changed("cpu-throttling") {
+		nc.CPUThrottlingRate = new(float64)
+		*nc.CPUThrottlingRate, err = cmd.Flags().GetFloat64("cpu-throttling")
+		if err != nil {
+			return nil, err
+		}
+		emulateNetwork = true
+	}
+	if emulateNetwork {
+		ts.NetworkConditions = nc
+	}
+
 	*ts.Completion.Timeout, err = cmd.Flags().GetInt("timeout")
 	if err != nil {
 		return nil, err
@@ -200,10 +321,82 @@ func BuildCompressedTaskSet(cmd *cobra.Command, args []string) (*b.CompressedTas
 	if err != nil {
 		return nil, err
 	}
+	*ts.Data.ScreenshotFullPage, err = cmd.Flags().GetBool("screenshot-full-page")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.ScreenshotInterval, err = cmd.Flags().GetInt("screenshot-interval")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.ScreenshotFormat, err = cmd.Flags().GetString("screenshot-format")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.ScreenshotQuality, err = cmd.Flags().GetInt("screenshot-quality")
+	if err != nil {
+		return nil, err
+	}
 	*ts.Data.ScriptMetadata, err = cmd.Flags().GetBool("script-metadata")
 	if err != nil {
 		return nil, err
 	}
+	*ts.Data.WebSocket, err = cmd.Flags().GetBool("websocket")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.EventSourceMessages, err = cmd.Flags().GetBool("event-source-messages")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.HAR, err = cmd.Flags().GetBool("har")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.WARC, err = cmd.Flags().GetBool("warc")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.Console, err = cmd.Flags().GetBool("console")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.JSCoverage, err = cmd.Flags().GetBool("js-coverage")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.Screencast, err = cmd.Flags().GetBool("screencast")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.ScreencastGIF, err = cmd.Flags().GetBool("screencast-gif")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.MHTML, err = cmd.Flags().GetBool("mhtml")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.DOMSnapshot, err = cmd.Flags().GetBool("dom-snapshot")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.DOMSnapshotStyles, err = cmd.Flags().GetStringSlice("dom-snapshot-styles")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.WebStorage, err = cmd.Flags().GetBool("web-storage")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.Frames, err = cmd.Flags().GetBool("frames")
+	if err != nil {
+		return nil, err
+	}
+	*ts.Data.Downloads, err = cmd.Flags().GetBool("downloads")
+	if err != nil {
+		return nil, err
+	}
 	*ts.Data.BrowserCoverage, err = cmd.Flags().GetBool("browser-coverage")
 	if err != nil {
 		return nil, err
@@ -0,0 +1,46 @@
+package postprocess
+
+import (
+	"github.com/chromedp/cdproto/network"
+	b "github.com/teamnsrg/mida/base"
+	"net/url"
+	"strings"
+)
+
+// Cookies marks the cookies gathered from the browser which we seeded before navigation, as long as
+// they still have the value we gave them, so they can be told apart from cookies set by the page
+func Cookies(cookies []*network.Cookie, seeds []*network.CookieParam) []*b.DTCookie {
+	result := make([]*b.DTCookie, 0, len(cookies))
+	for _, c := range cookies {
+		dtCookie := &b.DTCookie{
+			Cookie: c,
+		}
+		for _, seed := range seeds {
+			if seededCookie(c, seed) {
+				dtCookie.Seeded = true
+				break
+			}
+		}
+		result = append(result, dtCookie)
+	}
+
+	return result
+}
+
+// seededCookie returns true if a cookie is the one created from a seed. Seeds without a domain create
+// host-only cookies for the host of their URL, while the browser reports domain cookies with a leading dot.
+func seededCookie(c *network.Cookie, seed *network.CookieParam) bool {
+	if c.Name != seed.Name || c.Value != seed.Value || c.Path != seed.Path {
+		return false
+	}
+
+	if seed.Domain != "" {
+		return strings.TrimPrefix(c.Domain, ".") == strings.TrimPrefix(strings.ToLower(seed.Domain), ".")
+	}
+
+	u, err := url.Parse(seed.URL)
+	if err != nil {
+		return false
+	}
+	return c.Domain == u.Hostname()
+}
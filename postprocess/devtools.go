@@ -3,13 +3,14 @@ package postprocess
 import (
 	"bufio"
 	"errors"
-	"github.com/chromedp/cdproto/debugger"
+	"github.com/chromedp/cdproto/network"
 	b "github.com/teamnsrg/mida/base"
 	"github.com/teamnsrg/mida/log"
 	pp "github.com/teamnsrg/profparse"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,7 +25,10 @@ func DevTools(rr *b.RawResult) (b.FinalResult, error) {
 	finalResult := b.FinalResult{
 		Summary:            rr.TaskSummary,
 		DTResourceMetadata: make(map[string]b.DTResource),
-		DTScriptMetadata:   make(map[string]*debugger.EventScriptParsed),
+		DTScriptMetadata:   make(map[string]*b.DTScript),
+		DTWebSockets:       make(map[string]*b.DTWebSocket),
+
+		DTEventSourceMessages: make(map[string][]*network.EventEventSourceMessageReceived),
 	}
 
 	finalResult.Summary.TaskTiming.BeginPostprocess = time.Now()
@@ -34,42 +38,180 @@ func DevTools(rr *b.RawResult) (b.FinalResult, error) {
 	st := tw.SanitizedTask
 	log.Log.WithField("URL", st.URL).Debug("Begin Postprocess")
 
-	// Ignore any requests/responses which do not have a matching request/response
 	if *st.DS.ResourceMetadata {
-		for k := range rr.DevTools.Network.RequestWillBeSent {
-			if _, ok := rr.DevTools.Network.ResponseReceived[k]; ok {
-
-				/*
-					var tdl int64 = -1
-					if _, okData := rr.DataLengths[k]; okData {
-						tdl = rawResult.DataLengths[k]
-					}
-				*/
-
-				finalResult.DTResourceMetadata[k] = b.DTResource{
-					Requests: rr.DevTools.Network.RequestWillBeSent[k],
-					Response: rr.DevTools.Network.ResponseReceived[k],
-					// TotalDataLength: tdl,
-				}
-
-			}
-		}
+		finalResult.DTResourceMetadata = ResourceMetadata(&rr.DevTools.Network)
 	}
 
-	if *st.DS.ScriptMetadata {
+	// Coverage is stored alongside script metadata, so we need the metadata for either one
+	if *st.DS.ScriptMetadata || *st.DS.JSCoverage {
 		for _, v := range rr.DevTools.Scripts {
 			if _, ok := finalResult.DTScriptMetadata[v.ScriptID.String()]; ok {
 				rr.TaskSummary.TaskWrapper.Log.Warnf("found duplicate scriptId: %s", v.ScriptID.String())
 			} else {
-				finalResult.DTScriptMetadata[v.ScriptID.String()] = v
+				finalResult.DTScriptMetadata[v.ScriptID.String()] = &b.DTScript{EventScriptParsed: v}
 			}
 		}
 
 		finalResult.Summary.NumScripts = len(rr.DevTools.Scripts)
 	}
 
+	if *st.DS.JSCoverage {
+		for _, sc := range rr.DevTools.JSCoverage {
+			script, ok := finalResult.DTScriptMetadata[sc.ScriptID.String()]
+			if !ok {
+				tw.Log.Debugf("got coverage for unknown script: %s", sc.ScriptID.String())
+				continue
+			}
+			script.Coverage = JSCoverage(sc, script.Length)
+		}
+	}
+
+	if *st.DS.WebSocket {
+		ws := rr.DevTools.WebSockets
+		for k, v := range ws.Created {
+			webSocket := &b.DTWebSocket{
+				URL:               v.URL,
+				Initiator:         v.Initiator,
+				HandshakeRequest:  ws.HandshakeRequest[k],
+				HandshakeResponse: ws.HandshakeResponse[k],
+				Frames:            ws.Frames[k],
+				FrameErrors:       ws.FrameErrors[k],
+			}
+			if webSocket.Frames == nil {
+				webSocket.Frames = make([]*b.DTWebSocketFrame, 0)
+			}
+			if closed, ok := ws.Closed[k]; ok {
+				webSocket.Closed = closed.Timestamp
+			}
+			finalResult.DTWebSockets[k] = webSocket
+		}
+	}
+
+	if *st.DS.EventSourceMessages {
+		finalResult.DTEventSourceMessages = rr.DevTools.Network.EventSourceMessageReceived
+	}
+
+	if *st.DS.HAR {
+		resourceDir := ""
+		if *st.DS.AllResources {
+			resourceDir = path.Join(tw.TempDir, b.DefaultResourceSubdir)
+		}
+		finalResult.HAR = BuildHAR(rr, resourceDir)
+	}
+
+	if *st.DS.WARC {
+		err := WriteWARC(rr, path.Join(tw.TempDir, b.DefaultResourceSubdir), path.Join(tw.TempDir, b.DefaultWARCFile))
+		if err != nil {
+			tw.Log.Error("failed to write WARC file: " + err.Error())
+		}
+	}
+
+	if *st.DS.Screencast {
+		screencastDir := path.Join(tw.TempDir, b.DefaultScreencastSubdir)
+		err := WriteScreencastIndex(rr.DevTools.Screencast, screencastDir)
+		if err != nil {
+			tw.Log.Error("failed to write screencast index: " + err.Error())
+		}
+
+		if *st.DS.ScreencastGIF {
+			err = WriteScreencastGIF(rr.DevTools.Screencast, screencastDir, path.Join(tw.TempDir, b.DefaultScreencastGIFFile))
+			if err != nil {
+				tw.Log.Error("failed to assemble screencast GIF: " + err.Error())
+			}
+		}
+	}
+
+	if *st.DS.MHTML {
+		finalResult.MHTML = rr.DevTools.MHTML
+	}
+
+	if *st.DS.Downloads {
+		finalResult.DTDownloads = Downloads(rr.DevTools.Downloads, path.Join(tw.TempDir, b.DefaultDownloadSubdir))
+	}
+
+	if *st.IS.FollowPopups {
+		finalResult.DTPopups = make([]*b.DTPopup, 0, len(rr.DevTools.Popups))
+		for _, popup := range rr.DevTools.Popups {
+			resources := ResourceMetadata(&popup.Network)
+			finalResult.DTPopups = append(finalResult.DTPopups, &b.DTPopup{
+				Index:            popup.Index,
+				TargetID:         popup.TargetID,
+				OpenerID:         popup.OpenerID,
+				OpenerFrameID:    popup.OpenerFrameID,
+				InitialURL:       popup.InitialURL,
+				Opened:           popup.Opened,
+				NavHistory:       popup.NavHistory,
+				NumResources:     len(popup.Network.RequestWillBeSent),
+				ResourceMetadata: resources,
+				Screenshot:       popup.Screenshot,
+			})
+		}
+		sort.Slice(finalResult.DTPopups, func(i, j int) bool {
+			return finalResult.DTPopups[i].Index < finalResult.DTPopups[j].Index
+		})
+		finalResult.Summary.NumPopups = len(finalResult.DTPopups)
+	}
+
+	if len(st.InitScripts) > 0 {
+		finalResult.DTInstrumentation = rr.DevTools.Instrumentation
+	}
+
+	if *st.DS.Frames {
+		finalResult.DTFrames = Frames(&rr.DevTools.Frames, &rr.DevTools.Network)
+	}
+
+	if *st.DS.WebStorage && rr.DevTools.WebStorage != nil {
+		finalResult.DTWebStorage = rr.DevTools.WebStorage
+		finalResult.Summary.WebStorageData = &b.WebStorageMetadata{
+			NumOrigins: len(rr.DevTools.WebStorage),
+		}
+		for _, originStorage := range rr.DevTools.WebStorage {
+			finalResult.Summary.WebStorageData.NumLocalStorageItems += len(originStorage.LocalStorage)
+			finalResult.Summary.WebStorageData.NumSessionStorageItems += len(originStorage.SessionStorage)
+			finalResult.Summary.WebStorageData.NumIndexedDBDatabases += len(originStorage.IndexedDB)
+			for _, db := range originStorage.IndexedDB {
+				for _, store := range db.ObjectStores {
+					finalResult.Summary.WebStorageData.NumIndexedDBEntries += store.NumEntries
+				}
+			}
+		}
+	}
+
+	if *st.DS.DOMSnapshot && rr.DevTools.DOMSnapshot != nil {
+		finalResult.DTDOMSnapshot = DOMSnapshot(rr.DevTools.DOMSnapshot, *st.DS.DOMSnapshotStyles)
+	}
+
+	if *st.IS.TriggerEventListeners {
+		finalResult.DTEventListeners = rr.DevTools.EventListeners
+	}
+
+	if len(st.RequestRules) > 0 {
+		finalResult.DTRequestRuleHits = make([]*b.DTRequestRuleHit, 0, len(rr.DevTools.RequestRuleHits))
+		finalResult.DTRequestRuleHits = append(finalResult.DTRequestRuleHits, rr.DevTools.RequestRuleHits...)
+	}
+
+	if *st.DS.Console {
+		console := rr.DevTools.Console
+		finalResult.DTConsole = &console
+		finalResult.Summary.ConsoleData = b.ConsoleMetadata{
+			NumConsoleAPICalls: len(console.ConsoleAPICalls),
+			NumExceptions:      len(console.Exceptions),
+			NumLogEntries:      len(console.LogEntries),
+			ConsoleAPICallType: make(map[string]int),
+			LogEntryLevels:     make(map[string]int),
+		}
+		for _, c := range console.ConsoleAPICalls {
+			finalResult.Summary.ConsoleData.ConsoleAPICallType[c.Type.String()] += 1
+		}
+		for _, e := range console.LogEntries {
+			if e.Entry != nil {
+				finalResult.Summary.ConsoleData.LogEntryLevels[e.Entry.Level.String()] += 1
+			}
+		}
+	}
+
 	if *st.DS.Cookies {
-		finalResult.DTCookies = rr.DevTools.Cookies
+		finalResult.DTCookies = Cookies(rr.DevTools.Cookies, st.SeedCookies)
 	}
 
 	if *st.DS.DOM {
@@ -168,6 +310,45 @@ func DevTools(rr *b.RawResult) (b.FinalResult, error) {
 	return finalResult, nil
 }
 
+// ResourceMetadata pairs up the requests and responses gathered during a site visit. Requests/responses
+// without a matching response/request are ignored, with the exception of requests we blocked using filter
+// lists, which never get a response but which we still want to label.
+func ResourceMetadata(networkData *b.DevToolsNetworkRawData) map[string]b.DTResource {
+	result := make(map[string]b.DTResource)
+	for k := range networkData.RequestWillBeSent {
+		if _, ok := networkData.ResponseReceived[k]; ok || networkData.FilterBlocked[k] {
+
+			/*
+				var tdl int64 = -1
+				if _, okData := rr.DataLengths[k]; okData {
+					tdl = rawResult.DataLengths[k]
+				}
+			*/
+
+			frameID := ""
+			if reqs := networkData.RequestWillBeSent[k]; len(reqs) > 0 {
+				frameID = reqs[0].FrameID.String()
+			}
+
+			result[k] = b.DTResource{
+				Requests: networkData.RequestWillBeSent[k],
+				Response: networkData.ResponseReceived[k],
+				// TotalDataLength: tdl,
+
+				EventSourceMessages: networkData.EventSourceMessageReceived[k],
+				FilterMatch:         networkData.FilterMatches[k],
+				FrameID:             frameID,
+			}
+			if fm := networkData.FilterMatches[k]; fm != nil {
+				fm.Blocked = networkData.FilterBlocked[k]
+			}
+
+		}
+	}
+
+	return result
+}
+
 func ParseMergedTextfile(fname string, mapping map[string]int) ([]bool, int, error) {
 	if covMappingLength == 0 || covMapping == nil {
 		return nil, 0, errors.New("coverage map has not been initialized")
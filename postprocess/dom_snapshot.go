@@ -0,0 +1,155 @@
+package postprocess
+
+import (
+	"github.com/chromedp/cdproto/domsnapshot"
+	b "github.com/teamnsrg/mida/base"
+)
+
+// Node type of elements, as defined by the DOM standard
+const elementNodeType = 1
+
+// DOMSnapshot converts a raw DOM snapshot, in which strings are stored once and referenced by index,
+// into a list of the element nodes in each document. styles must be the list of computed styles
+// which was passed to the browser when the snapshot was captured.
+func DOMSnapshot(raw *b.DevToolsDOMSnapshotRawData, styles []string) *b.DTDOMSnapshot {
+	result := &b.DTDOMSnapshot{
+		Documents: make([]*b.DTSnapshotDocument, 0, len(raw.Documents)),
+	}
+
+	lookup := func(i int64) string {
+		if i < 0 || i >= int64(len(raw.Strings)) {
+			return ""
+		}
+		return raw.Strings[i]
+	}
+
+	for _, doc := range raw.Documents {
+		sd := &b.DTSnapshotDocument{
+			URL:           lookup(int64(doc.DocumentURL)),
+			Title:         lookup(int64(doc.Title)),
+			FrameID:       lookup(int64(doc.FrameID)),
+			ContentWidth:  doc.ContentWidth,
+			ContentHeight: doc.ContentHeight,
+			Nodes:         make([]*b.DTSnapshotNode, 0),
+		}
+		result.Documents = append(result.Documents, sd)
+
+		nodes := doc.Nodes
+		if nodes == nil {
+			continue
+		}
+
+		// Layout information is stored separately, only for the nodes which were rendered
+		layoutIndex := make(map[int64]int)
+		if doc.Layout != nil {
+			for i, nodeIndex := range doc.Layout.NodeIndex {
+				layoutIndex[nodeIndex] = i
+			}
+		}
+
+		contentDocuments := make(map[int64]int64)
+		if nodes.ContentDocumentIndex != nil {
+			for i, nodeIndex := range nodes.ContentDocumentIndex.Index {
+				if i < len(nodes.ContentDocumentIndex.Value) {
+					contentDocuments[nodeIndex] = nodes.ContentDocumentIndex.Value[i]
+				}
+			}
+		}
+
+		// Elements with opacity 0 are invisible along with everything inside them. Other styles need no such
+		// treatment: visibility is inherited by the computed style, and descendants of display:none are not rendered.
+		transparent := make(map[int64]bool)
+
+		for i, nodeType := range nodes.NodeType {
+			if nodeType != elementNodeType {
+				continue
+			}
+			nodeIndex := int64(i)
+
+			node := &b.DTSnapshotNode{
+				NodeIndex:   nodeIndex,
+				ParentIndex: -1,
+			}
+			if i < len(nodes.ParentIndex) {
+				node.ParentIndex = nodes.ParentIndex[i]
+			}
+			if i < len(nodes.BackendNodeID) {
+				node.BackendNodeID = nodes.BackendNodeID[i]
+			}
+			if i < len(nodes.NodeName) {
+				node.NodeName = lookup(int64(nodes.NodeName[i]))
+			}
+			if i < len(nodes.Attributes) && len(nodes.Attributes[i]) > 0 {
+				node.Attributes = make(map[string]string)
+				attrs := nodes.Attributes[i]
+				for j := 0; j+1 < len(attrs); j += 2 {
+					node.Attributes[lookup(int64(attrs[j]))] = lookup(int64(attrs[j+1]))
+				}
+			}
+			if cd, ok := contentDocuments[nodeIndex]; ok {
+				node.ContentDocument = &cd
+			}
+
+			if li, ok := layoutIndex[nodeIndex]; ok {
+				applyLayout(node, doc.Layout, li, styles, lookup)
+			}
+			transparent[nodeIndex] = transparent[node.ParentIndex] || node.Styles["opacity"] == "0"
+			if transparent[node.ParentIndex] {
+				node.Visible = false
+			}
+
+			sd.Nodes = append(sd.Nodes, node)
+		}
+	}
+
+	// The contents of a frame can only be seen if the frame itself can
+	hidden := make(map[int64]bool)
+	for _, sd := range result.Documents {
+		for _, node := range sd.Nodes {
+			if node.ContentDocument != nil && !node.Visible {
+				hideDocument(result, *node.ContentDocument, hidden)
+			}
+		}
+	}
+
+	return result
+}
+
+// hideDocument marks every element of a document, and of the documents nested within it, as not visible
+func hideDocument(snapshot *b.DTDOMSnapshot, docIndex int64, hidden map[int64]bool) {
+	if docIndex < 0 || docIndex >= int64(len(snapshot.Documents)) || hidden[docIndex] {
+		return
+	}
+	hidden[docIndex] = true
+
+	for _, node := range snapshot.Documents[docIndex].Nodes {
+		node.Visible = false
+		if node.ContentDocument != nil {
+			hideDocument(snapshot, *node.ContentDocument, hidden)
+		}
+	}
+}
+
+// applyLayout fills in the layout information for a node which was rendered, and decides whether it was visible
+func applyLayout(node *b.DTSnapshotNode, layout *domsnapshot.LayoutTreeSnapshot, li int,
+	styles []string, lookup func(int64) string) {
+	if li < len(layout.Bounds) {
+		node.Bounds = layout.Bounds[li]
+	}
+	if li < len(layout.PaintOrders) {
+		node.PaintOrder = layout.PaintOrders[li]
+	}
+	if li < len(layout.Styles) {
+		node.Styles = make(map[string]string)
+		for j, si := range layout.Styles[li] {
+			if j < len(styles) {
+				node.Styles[styles[j]] = lookup(int64(si))
+			}
+		}
+	}
+
+	node.Visible = len(node.Bounds) == 4 && node.Bounds[2] > 0 && node.Bounds[3] > 0 &&
+		node.Styles["display"] != "none" &&
+		node.Styles["visibility"] != "hidden" && node.Styles["visibility"] != "collapse" &&
+		node.Styles["opacity"] != "0"
+}
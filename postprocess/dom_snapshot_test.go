@@ -0,0 +1,65 @@
+package postprocess
+
+import (
+	"github.com/chromedp/cdproto/domsnapshot"
+	b "github.com/teamnsrg/mida/base"
+	"testing"
+)
+
+// TestDOMSnapshotHiddenAncestors checks that elements inside a transparent element, and the contents
+// of a frame which cannot be seen, are not reported as visible
+func TestDOMSnapshotHiddenAncestors(t *testing.T) {
+	t.Parallel()
+
+	styles := []string{"display", "visibility", "opacity"}
+	shown := domsnapshot.ArrayOfStrings{3, 4, 6}
+	transparent := domsnapshot.ArrayOfStrings{3, 4, 5}
+	box := domsnapshot.Rectangle{0, 0, 100, 100}
+
+	raw := &b.DevToolsDOMSnapshotRawData{
+		Strings: []string{"", "DIV", "IFRAME", "block", "visible", "0", "1"},
+		Documents: []*domsnapshot.DocumentSnapshot{
+			{
+				Nodes: &domsnapshot.NodeTreeSnapshot{
+					ParentIndex: []int64{-1, 0, 1, 0, 0},
+					NodeType:    []int64{9, 1, 1, 1, 1},
+					NodeName:    []domsnapshot.StringIndex{0, 1, 1, 2, 1},
+					ContentDocumentIndex: &domsnapshot.RareIntegerData{
+						Index: []int64{3},
+						Value: []int64{1},
+					},
+				},
+				Layout: &domsnapshot.LayoutTreeSnapshot{
+					NodeIndex: []int64{1, 2, 3, 4},
+					Styles:    []domsnapshot.ArrayOfStrings{transparent, shown, shown, shown},
+					Bounds:    []domsnapshot.Rectangle{box, box, {0, 0, 0, 0}, box},
+				},
+			},
+			{
+				Nodes: &domsnapshot.NodeTreeSnapshot{
+					ParentIndex: []int64{-1, 0},
+					NodeType:    []int64{9, 1},
+					NodeName:    []domsnapshot.StringIndex{0, 1},
+				},
+				Layout: &domsnapshot.LayoutTreeSnapshot{
+					NodeIndex: []int64{1},
+					Styles:    []domsnapshot.ArrayOfStrings{shown},
+					Bounds:    []domsnapshot.Rectangle{box},
+				},
+			},
+		},
+	}
+
+	snapshot := DOMSnapshot(raw, styles)
+	expected := [][]bool{{false, false, false, true}, {false}}
+	for i, doc := range snapshot.Documents {
+		if len(doc.Nodes) != len(expected[i]) {
+			t.Fatalf("document %d: expected %d elements, got %d", i, len(expected[i]), len(doc.Nodes))
+		}
+		for j, node := range doc.Nodes {
+			if node.Visible != expected[i][j] {
+				t.Fatalf("document %d, element %d: expected visible to be %v", i, node.NodeIndex, expected[i][j])
+			}
+		}
+	}
+}
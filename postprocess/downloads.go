@@ -0,0 +1,65 @@
+package postprocess
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/chromedp/cdproto/browser"
+	b "github.com/teamnsrg/mida/base"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+)
+
+// Downloads lists the downloads made during a site visit in the order they began, hashing and
+// sniffing the MIME type of each file which completed. downloadDir is the directory the browser
+// saved the downloads to.
+func Downloads(downloads map[string]*b.DTDownload, downloadDir string) []*b.DTDownload {
+	result := make([]*b.DTDownload, 0, len(downloads))
+	for _, download := range downloads {
+		result = append(result, download)
+
+		if download.State != browser.DownloadProgressStateCompleted.String() {
+			continue
+		}
+
+		sum, mimeType, err := hashDownload(path.Join(downloadDir, download.GUID))
+		if err != nil {
+			continue
+		}
+		download.SHA256 = sum
+		download.MIMEType = mimeType
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Began.Before(result[j].Began)
+	})
+
+	return result
+}
+
+// hashDownload returns the SHA-256 hash (in hex) and the sniffed MIME type of a downloaded file
+func hashDownload(fileName string) (string, string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	// http.DetectContentType considers at most the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", "", err
+	}
+
+	h := sha256.New()
+	h.Write(head[:n])
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), http.DetectContentType(head[:n]), nil
+}
@@ -0,0 +1,95 @@
+package postprocess
+
+import (
+	"github.com/chromedp/cdproto/page"
+	b "github.com/teamnsrg/mida/base"
+	"net/url"
+	"sort"
+)
+
+// Frames builds the frame tree from the frame events gathered during a site visit, and attributes each
+// request to the frame which made it. Frames which were detached along the way are kept (and marked).
+func Frames(raw *b.DevToolsFrameRawData, networkData *b.DevToolsNetworkRawData) map[string]*b.DTFrame {
+	frames := make(map[string]*b.DTFrame)
+	getFrame := func(id string) *b.DTFrame {
+		if _, ok := frames[id]; !ok {
+			frames[id] = &b.DTFrame{
+				ID:         id,
+				URLHistory: make([]string, 0),
+				RequestIDs: make([]string, 0),
+			}
+		}
+		return frames[id]
+	}
+
+	for _, ev := range raw.Attached {
+		getFrame(ev.FrameID.String()).ParentID = ev.ParentFrameID.String()
+	}
+
+	for _, ev := range raw.Navigated {
+		if ev.Frame == nil {
+			continue
+		}
+		f := getFrame(ev.Frame.ID.String())
+		if ev.Frame.ParentID != "" {
+			f.ParentID = ev.Frame.ParentID.String()
+		}
+		f.Name = ev.Frame.Name
+		f.Origin = ev.Frame.SecurityOrigin
+		frameNavigated(f, ev.Frame.URL+ev.Frame.URLFragment)
+	}
+
+	for _, info := range raw.IframeTargets {
+		f := getFrame(info.TargetID.String())
+		f.OutOfProcess = true
+		if info.URL != "" && info.URL != "about:blank" {
+			f.Origin = urlOrigin(info.URL)
+			frameNavigated(f, info.URL)
+		}
+	}
+
+	// A frame which is swapped into another process is detached and reattached, so it is still present
+	for _, ev := range raw.Detached {
+		if ev.Reason == page.FrameDetachedReasonSwap {
+			continue
+		}
+		getFrame(ev.FrameID.String()).Detached = true
+	}
+
+	for requestID, requests := range networkData.RequestWillBeSent {
+		if len(requests) == 0 || requests[0].FrameID == "" {
+			continue
+		}
+		f, ok := frames[requests[0].FrameID.String()]
+		if !ok {
+			continue
+		}
+		f.RequestIDs = append(f.RequestIDs, requestID)
+	}
+
+	for _, f := range frames {
+		sort.Strings(f.RequestIDs)
+		if parent, ok := frames[f.ParentID]; ok {
+			f.CrossOrigin = f.Origin != parent.Origin
+		}
+	}
+
+	return frames
+}
+
+// frameNavigated records a navigation of a frame, ignoring repeated events for the same URL
+func frameNavigated(f *b.DTFrame, u string) {
+	f.URL = u
+	if len(f.URLHistory) == 0 || f.URLHistory[len(f.URLHistory)-1] != u {
+		f.URLHistory = append(f.URLHistory, u)
+	}
+}
+
+// urlOrigin returns the origin (scheme and host) of a URL, in the form the browser uses for security origins
+func urlOrigin(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return "null"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
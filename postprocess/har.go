@@ -0,0 +1,262 @@
+package postprocess
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/har"
+	"github.com/chromedp/cdproto/network"
+	b "github.com/teamnsrg/mida/base"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const harPageID = "page_1"
+
+// Timestamps always have the same width (unlike time.RFC3339Nano, which drops trailing zeros), so they sort correctly as strings
+const harTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// BuildHAR converts the network data gathered during a site visit into a HAR 1.2 log. Redirects are
+// reconstructed from the request arrays (each redirect shows up as a new request carrying the response
+// to the previous one). If resourceDir is not empty, response bodies are read from the files stored there.
+func BuildHAR(rr *b.RawResult, resourceDir string) *har.HAR {
+	ci := rr.TaskSummary.CrawlerInfo
+	log := &har.Log{
+		Version: "1.2",
+		Creator: &har.Creator{Name: "MIDA"},
+		Browser: &har.Creator{Name: ci.Browser, Version: ci.BrowserVersion},
+		Entries: make([]*har.Entry, 0),
+	}
+
+	networkData := rr.DevTools.Network
+	for requestID, requests := range networkData.RequestWillBeSent {
+		for i, req := range requests {
+			var resp *network.Response
+			var finished *network.EventLoadingFinished
+			last := i == len(requests)-1
+			if !last {
+				resp = requests[i+1].RedirectResponse
+			} else {
+				if ev, ok := networkData.ResponseReceived[requestID]; ok {
+					resp = ev.Response
+				}
+				finished = networkData.LoadingFinished[requestID]
+			}
+
+			var body []byte
+			if last && resourceDir != "" {
+				body, _ = ioutil.ReadFile(path.Join(resourceDir, requestID))
+			}
+
+			log.Entries = append(log.Entries, harEntry(req, resp, finished, body))
+		}
+	}
+
+	sort.Slice(log.Entries, func(i, j int) bool {
+		return log.Entries[i].StartedDateTime < log.Entries[j].StartedDateTime
+	})
+
+	if len(log.Entries) > 0 {
+		started, err := time.Parse(harTimeFormat, log.Entries[0].StartedDateTime)
+		page := &har.Page{
+			StartedDateTime: log.Entries[0].StartedDateTime,
+			ID:              harPageID,
+			Title:           rr.TaskSummary.TaskWrapper.SanitizedTask.URL,
+			PageTimings:     &har.PageTimings{OnContentLoad: -1, OnLoad: -1},
+		}
+		if err == nil && !rr.TaskSummary.TaskTiming.LoadEvent.IsZero() {
+			page.PageTimings.OnLoad = msSince(started, rr.TaskSummary.TaskTiming.LoadEvent)
+		}
+		log.Pages = []*har.Page{page}
+	}
+
+	return &har.HAR{Log: log}
+}
+
+// harEntry builds a single HAR entry from a request and (possibly nil) response
+func harEntry(req *network.EventRequestWillBeSent, resp *network.Response, finished *network.EventLoadingFinished, body []byte) *har.Entry {
+	entry := &har.Entry{
+		Pageref: harPageID,
+		Request: &har.Request{
+			Method:      req.Request.Method,
+			URL:         req.Request.URL + req.Request.URLFragment,
+			HTTPVersion: "",
+			Cookies:     make([]*har.Cookie, 0),
+			Headers:     harHeaders(req.Request.Headers),
+			QueryString: harQueryString(req.Request.URL),
+			HeadersSize: -1,
+			BodySize:    int64(len(req.Request.PostData)),
+		},
+		Response: &har.Response{
+			Cookies:     make([]*har.Cookie, 0),
+			Headers:     make([]*har.NameValuePair, 0),
+			Content:     &har.Content{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Cache:   &har.Cache{},
+		Timings: &har.Timings{Blocked: -1, DNS: -1, Connect: -1, Ssl: -1},
+	}
+
+	if req.WallTime != nil {
+		entry.StartedDateTime = req.WallTime.Time().UTC().Format(harTimeFormat)
+	}
+
+	if req.Request.HasPostData {
+		entry.Request.PostData = &har.PostData{
+			MimeType: headerValue(req.Request.Headers, "Content-Type"),
+			Params:   make([]*har.Param, 0),
+			Text:     req.Request.PostData,
+		}
+	}
+
+	if resp == nil {
+		entry.Comment = "no response received"
+		return entry
+	}
+
+	httpVersion := harHTTPVersion(resp.Protocol)
+	entry.Request.HTTPVersion = httpVersion
+	if resp.RequestHeaders != nil {
+		entry.Request.Headers = harHeaders(resp.RequestHeaders)
+	}
+
+	entry.Response.Status = resp.Status
+	entry.Response.StatusText = resp.StatusText
+	entry.Response.HTTPVersion = httpVersion
+	entry.Response.Headers = harHeaders(resp.Headers)
+	entry.Response.RedirectURL = headerValue(resp.Headers, "Location")
+	entry.Response.Content.MimeType = resp.MimeType
+	entry.ServerIPAddress = strings.Trim(resp.RemoteIPAddress, "[]")
+	if resp.ConnectionID != 0 {
+		entry.Connection = fmt.Sprintf("%.0f", resp.ConnectionID)
+	}
+
+	if body != nil {
+		entry.Response.Content.Size = int64(len(body))
+		if isTextMimeType(resp.MimeType) && utf8.Valid(body) {
+			entry.Response.Content.Text = string(body)
+		} else {
+			entry.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+			entry.Response.Content.Encoding = "base64"
+		}
+	} else if finished != nil {
+		entry.Response.Content.Size = int64(finished.EncodedDataLength)
+	}
+
+	if resp.Timing != nil {
+		entry.Timings = harTimings(resp.Timing, finished)
+	}
+	for _, t := range []float64{entry.Timings.Blocked, entry.Timings.DNS, entry.Timings.Connect,
+		entry.Timings.Send, entry.Timings.Wait, entry.Timings.Receive} {
+		if t > 0 {
+			entry.Time += t
+		}
+	}
+
+	return entry
+}
+
+// harTimings converts DevTools resource timing into HAR timings. DevTools gives us offsets (in milliseconds)
+// from a base request time, while HAR wants the duration of each phase.
+func harTimings(rt *network.ResourceTiming, finished *network.EventLoadingFinished) *har.Timings {
+	t := &har.Timings{Blocked: -1, DNS: -1, Connect: -1, Ssl: -1}
+
+	for _, start := range []float64{rt.DNSStart, rt.ConnectStart, rt.SendStart} {
+		if start >= 0 {
+			t.Blocked = start
+			break
+		}
+	}
+	if rt.DNSStart >= 0 {
+		t.DNS = rt.DNSEnd - rt.DNSStart
+	}
+	if rt.ConnectStart >= 0 {
+		t.Connect = rt.ConnectEnd - rt.ConnectStart
+	}
+	if rt.SslStart >= 0 {
+		t.Ssl = rt.SslEnd - rt.SslStart
+	}
+	t.Send = rt.SendEnd - rt.SendStart
+	t.Wait = rt.ReceiveHeadersEnd - rt.SendEnd
+
+	if finished != nil && finished.Timestamp != nil {
+		finishedSeconds := finished.Timestamp.Time().Sub(*cdp.MonotonicTimeEpoch).Seconds()
+		receive := (finishedSeconds-rt.RequestTime)*1000 - rt.ReceiveHeadersEnd
+		if receive > 0 {
+			t.Receive = receive
+		}
+	}
+
+	return t
+}
+
+func harHeaders(headers network.Headers) []*har.NameValuePair {
+	result := make([]*har.NameValuePair, 0, len(headers))
+	for name, value := range headers {
+		// Chromium joins repeated headers with newlines
+		for _, v := range strings.Split(fmt.Sprint(value), "\n") {
+			result = append(result, &har.NameValuePair{Name: name, Value: v})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+func harQueryString(rawURL string) []*har.NameValuePair {
+	result := make([]*har.NameValuePair, 0)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return result
+	}
+	for name, values := range u.Query() {
+		for _, v := range values {
+			result = append(result, &har.NameValuePair{Name: name, Value: v})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// headerValue does a case-insensitive lookup of a header
+func headerValue(headers network.Headers, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+func harHTTPVersion(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "h2":
+		return "HTTP/2"
+	case "h3", "http/3":
+		return "HTTP/3"
+	case "":
+		return ""
+	default:
+		return strings.ToUpper(protocol)
+	}
+}
+
+func isTextMimeType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") || strings.Contains(mimeType, "json") ||
+		strings.Contains(mimeType, "javascript") || strings.Contains(mimeType, "xml")
+}
+
+func msSince(start time.Time, end time.Time) float64 {
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
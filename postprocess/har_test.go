@@ -0,0 +1,105 @@
+package postprocess
+
+import (
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	b "github.com/teamnsrg/mida/base"
+	"testing"
+	"time"
+)
+
+// TestBuildHARRedirects ensures that a request which was redirected once produces two
+// HAR entries, with the redirect response attached to the first entry.
+func TestBuildHARRedirects(t *testing.T) {
+	t.Parallel()
+
+	wallTime := cdp.TimeSinceEpoch(time.Now())
+	rr := &b.RawResult{
+		TaskSummary: b.TaskSummary{TaskWrapper: &b.TaskWrapper{}},
+		DevTools: b.DevToolsRawData{
+			Network: b.DevToolsNetworkRawData{
+				RequestWillBeSent: map[string][]*network.EventRequestWillBeSent{
+					"1": {
+						{
+							RequestID: "1",
+							WallTime:  &wallTime,
+							Request:   &network.Request{URL: "http://example.com/?a=b", Method: "GET"},
+						},
+						{
+							RequestID: "1",
+							WallTime:  &wallTime,
+							Request:   &network.Request{URL: "https://example.com/", Method: "GET"},
+							RedirectResponse: &network.Response{
+								Status:   301,
+								Headers:  network.Headers{"Location": "https://example.com/"},
+								Protocol: "http/1.1",
+							},
+						},
+					},
+				},
+				ResponseReceived: map[string]*network.EventResponseReceived{
+					"1": {RequestID: "1", Response: &network.Response{Status: 200, Protocol: "h2"}},
+				},
+			},
+		},
+	}
+
+	h := BuildHAR(rr, "")
+	if len(h.Log.Entries) != 2 {
+		t.Fatalf("expected 2 HAR entries, got %d", len(h.Log.Entries))
+	}
+
+	var redirect, final int
+	for i, e := range h.Log.Entries {
+		if e.Request.URL == "http://example.com/?a=b" {
+			redirect = i
+		} else {
+			final = i
+		}
+	}
+
+	if h.Log.Entries[redirect].Response.Status != 301 ||
+		h.Log.Entries[redirect].Response.RedirectURL != "https://example.com/" {
+		t.Fatal("redirect response was not attached to the original request")
+	}
+	if len(h.Log.Entries[redirect].Request.QueryString) != 1 {
+		t.Fatal("failed to parse query string")
+	}
+	if h.Log.Entries[final].Response.Status != 200 || h.Log.Entries[final].Response.HTTPVersion != "HTTP/2" {
+		t.Fatal("final response was not attached to the final request")
+	}
+}
+
+// TestBuildHAROrder checks that entries are ordered by start time, including times whose
+// fractional seconds differ in length
+func TestBuildHAROrder(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2020, 1, 1, 0, 0, 5, 0, time.UTC)
+	requests := make(map[string][]*network.EventRequestWillBeSent)
+	for i, offset := range []time.Duration{120 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond, 0} {
+		id := network.RequestID(string(rune('a' + i)))
+		wallTime := cdp.TimeSinceEpoch(base.Add(offset))
+		requests[id.String()] = []*network.EventRequestWillBeSent{{
+			RequestID: id,
+			WallTime:  &wallTime,
+			Request:   &network.Request{URL: "https://example.com/" + id.String(), Method: "GET"},
+		}}
+	}
+
+	h := BuildHAR(&b.RawResult{
+		TaskSummary: b.TaskSummary{TaskWrapper: &b.TaskWrapper{}},
+		DevTools:    b.DevToolsRawData{Network: b.DevToolsNetworkRawData{RequestWillBeSent: requests}},
+	}, "")
+
+	var order string
+	for _, e := range h.Log.Entries {
+		order += e.Request.URL[len(e.Request.URL)-1:]
+	}
+	if order != "dbac" {
+		t.Fatalf("entries out of order: %s", order)
+	}
+	if h.Log.Pages[0].StartedDateTime != "2020-01-01T00:00:05.000000000Z" {
+		t.Fatalf("wrong page start time: %s", h.Log.Pages[0].StartedDateTime)
+	}
+}
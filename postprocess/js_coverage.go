@@ -0,0 +1,62 @@
+package postprocess
+
+import (
+	"github.com/chromedp/cdproto/profiler"
+	b "github.com/teamnsrg/mida/base"
+	"sort"
+)
+
+// JSCoverage summarizes the precise coverage gathered for a single script. length is the length of
+// the script in bytes, as reported when it was parsed. If it is unknown (zero), we fall back to the
+// end of the outermost range, which covers the whole script.
+func JSCoverage(sc *profiler.ScriptCoverage, length int64) *b.DTScriptCoverage {
+	result := &b.DTScriptCoverage{
+		Functions:  sc.Functions,
+		TotalBytes: length,
+	}
+
+	ranges := make([]*profiler.CoverageRange, 0)
+	for _, f := range sc.Functions {
+		ranges = append(ranges, f.Ranges...)
+	}
+	if result.TotalBytes == 0 {
+		for _, r := range ranges {
+			if r.EndOffset > result.TotalBytes {
+				result.TotalBytes = r.EndOffset
+			}
+		}
+	}
+	if result.TotalBytes == 0 {
+		return result
+	}
+
+	// Ranges never partially overlap, and a nested range takes precedence over the range containing it.
+	// Ordering by start (and by end, descending, for ranges that start together) means that painting
+	// the ranges in order leaves each byte with the count from its innermost range.
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].StartOffset != ranges[j].StartOffset {
+			return ranges[i].StartOffset < ranges[j].StartOffset
+		}
+		return ranges[i].EndOffset > ranges[j].EndOffset
+	})
+
+	used := make([]bool, result.TotalBytes)
+	for _, r := range ranges {
+		end := r.EndOffset
+		if end > result.TotalBytes {
+			end = result.TotalBytes
+		}
+		for i := r.StartOffset; i < end; i++ {
+			used[i] = r.Count > 0
+		}
+	}
+
+	for _, u := range used {
+		if u {
+			result.UsedBytes += 1
+		}
+	}
+	result.PercentUsed = 100 * float64(result.UsedBytes) / float64(result.TotalBytes)
+
+	return result
+}
@@ -0,0 +1,51 @@
+package postprocess
+
+import (
+	"github.com/chromedp/cdproto/profiler"
+	"testing"
+)
+
+// TestJSCoverage checks that nested ranges take precedence over the ranges containing them
+func TestJSCoverage(t *testing.T) {
+	t.Parallel()
+
+	sc := &profiler.ScriptCoverage{
+		ScriptID: "1",
+		Functions: []*profiler.FunctionCoverage{
+			{
+				FunctionName: "",
+				Ranges: []*profiler.CoverageRange{
+					{StartOffset: 0, EndOffset: 100, Count: 1}, // Whole script ran
+				},
+			},
+			{
+				FunctionName: "f",
+				Ranges: []*profiler.CoverageRange{
+					{StartOffset: 20, EndOffset: 60, Count: 0}, // But this function never did,
+					{StartOffset: 30, EndOffset: 40, Count: 2}, // apart from this nested block
+				},
+			},
+			{
+				FunctionName: "g",
+				Ranges: []*profiler.CoverageRange{
+					{StartOffset: 90, EndOffset: 150, Count: 0}, // Extends past the end of the script
+				},
+			},
+		},
+	}
+
+	result := JSCoverage(sc, 100)
+	if result.TotalBytes != 100 {
+		t.Fatalf("expected 100 total bytes, got %d", result.TotalBytes)
+	}
+	// 0-20 and 60-90 from the script itself, plus 30-40 from the nested block
+	if result.UsedBytes != 60 || result.PercentUsed != 60 {
+		t.Fatalf("expected 60 used bytes (60%%), got %d (%f%%)", result.UsedBytes, result.PercentUsed)
+	}
+
+	// Without a known length, the length comes from the furthest range
+	result = JSCoverage(sc, 0)
+	if result.TotalBytes != 150 || result.UsedBytes != 60 {
+		t.Fatalf("expected 60 of 150 bytes used, got %d of %d", result.UsedBytes, result.TotalBytes)
+	}
+}
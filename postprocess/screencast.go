@@ -0,0 +1,95 @@
+package postprocess
+
+import (
+	"encoding/json"
+	b "github.com/teamnsrg/mida/base"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// WriteScreencastIndex writes the index of screencast frames (file names and metadata) into the screencast directory
+func WriteScreencastIndex(frames []*b.DTScreencastFrame, screencastDir string) error {
+	if frames == nil {
+		frames = make([]*b.DTScreencastFrame, 0)
+	}
+
+	data, err := json.Marshal(frames)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(screencastDir, b.DefaultScreencastIndexFile), data, 0644)
+}
+
+// WriteScreencastGIF assembles the frames of a screencast into an animated GIF at outFile, with each frame
+// shown until the next one arrived. Long screencasts are thinned out evenly to at most
+// DefaultScreencastGIFMaxFrames frames to keep memory usage (and file size) reasonable.
+func WriteScreencastGIF(frames []*b.DTScreencastFrame, screencastDir string, outFile string) error {
+	if len(frames) == 0 {
+		return nil
+	}
+
+	step := 1
+	if len(frames) > b.DefaultScreencastGIFMaxFrames {
+		step = (len(frames) + b.DefaultScreencastGIFMaxFrames - 1) / b.DefaultScreencastGIFMaxFrames
+	}
+
+	anim := &gif.GIF{}
+	var bounds image.Rectangle
+	for i := 0; i < len(frames); i += step {
+		f, err := os.Open(path.Join(screencastDir, frames[i].File))
+		if err != nil {
+			return err
+		}
+		img, err := jpeg.Decode(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		// Every frame is drawn onto a canvas the size of the first, in case the viewport changed during the visit
+		if len(anim.Image) == 0 {
+			bounds = img.Bounds()
+		}
+		paletted := image.NewPaletted(bounds, palette.Plan9)
+		draw.Draw(paletted, bounds, img, img.Bounds().Min, draw.Src)
+
+		// GIF delays are in hundredths of a second
+		delay := 100
+		next := i + step
+		if next >= len(frames) {
+			next = len(frames) - 1
+		}
+		if next > i {
+			delay = int(frameTime(frames[next]).Sub(frameTime(frames[i])) / (10 * time.Millisecond))
+		}
+		if delay < 0 {
+			delay = 0
+		}
+
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	out, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return gif.EncodeAll(out, anim)
+}
+
+func frameTime(frame *b.DTScreencastFrame) time.Time {
+	if frame.Metadata == nil || frame.Metadata.Timestamp == nil {
+		return time.Time{}
+	}
+	return frame.Metadata.Timestamp.Time()
+}
@@ -0,0 +1,266 @@
+package postprocess
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"github.com/chromedp/cdproto/network"
+	"github.com/google/uuid"
+	b "github.com/teamnsrg/mida/base"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers we drop from stored responses, since the bodies we get from the browser have already been decoded
+var warcStrippedResponseHeaders = []string{"content-encoding", "transfer-encoding", "content-length"}
+
+// warcRecord is a single WARC record, before serialization
+type warcRecord struct {
+	headers [][2]string
+	block   []byte
+}
+
+// WriteWARC converts the request/response pairs gathered during a site visit into a gzip-compressed
+// WARC/1.1 file at outFile. The file begins with a warcinfo record describing the crawler, followed by
+// a request and response record for each hop of each request. If resourceDir is not empty, response
+// bodies are read from the files stored there.
+func WriteWARC(rr *b.RawResult, resourceDir string, outFile string) error {
+	f, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now().UTC()
+	warcinfoID := warcRecordID()
+	ci := rr.TaskSummary.CrawlerInfo
+	info := "software: MIDA\r\n" +
+		"browser: " + ci.Browser + "\r\n" +
+		"browser-version: " + ci.BrowserVersion + "\r\n" +
+		"http-header-user-agent: " + ci.UserAgent + "\r\n" +
+		"js-version: " + ci.JSVersion + "\r\n" +
+		"format: WARC File Format 1.1\r\n" +
+		"conformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n"
+	err = writeWARCRecord(f, warcRecord{
+		headers: [][2]string{
+			{"WARC-Type", "warcinfo"},
+			{"WARC-Record-ID", warcinfoID},
+			{"WARC-Date", now.Format(time.RFC3339Nano)},
+			{"WARC-Filename", path.Base(outFile)},
+			{"Content-Type", "application/warc-fields"},
+		},
+		block: []byte(info),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write requests in the order they were sent so the archive is deterministic
+	networkData := rr.DevTools.Network
+	requestIDs := make([]string, 0, len(networkData.RequestWillBeSent))
+	for k, v := range networkData.RequestWillBeSent {
+		if len(v) > 0 {
+			requestIDs = append(requestIDs, k)
+		}
+	}
+	sort.Slice(requestIDs, func(i, j int) bool {
+		return requestStartTime(networkData.RequestWillBeSent[requestIDs[i]][0]).Before(
+			requestStartTime(networkData.RequestWillBeSent[requestIDs[j]][0]))
+	})
+
+	for _, requestID := range requestIDs {
+		requests := networkData.RequestWillBeSent[requestID]
+		for i, req := range requests {
+			var resp *network.Response
+			var body []byte
+			if i < len(requests)-1 {
+				resp = requests[i+1].RedirectResponse
+			} else if ev, ok := networkData.ResponseReceived[requestID]; ok {
+				resp = ev.Response
+				if resourceDir != "" {
+					body, _ = ioutil.ReadFile(path.Join(resourceDir, requestID))
+				}
+			}
+
+			// WARC can only hold HTTP(S) traffic, so skip data: URLs and the like
+			if !strings.HasPrefix(req.Request.URL, "http://") && !strings.HasPrefix(req.Request.URL, "https://") {
+				continue
+			}
+
+			date := now
+			if req.WallTime != nil {
+				date = req.WallTime.Time().UTC()
+			}
+
+			requestRecordID := warcRecordID()
+			requestHeaders := req.Request.Headers
+			if resp != nil && resp.RequestHeaders != nil {
+				requestHeaders = resp.RequestHeaders
+			}
+			err = writeWARCRecord(f, warcRecord{
+				headers: [][2]string{
+					{"WARC-Type", "request"},
+					{"WARC-Record-ID", requestRecordID},
+					{"WARC-Date", date.Format(time.RFC3339Nano)},
+					{"WARC-Target-URI", req.Request.URL},
+					{"WARC-Warcinfo-ID", warcinfoID},
+					{"Content-Type", "application/http;msgtype=request"},
+				},
+				block: httpRequestBlock(req.Request, requestHeaders),
+			})
+			if err != nil {
+				return err
+			}
+
+			if resp == nil {
+				continue
+			}
+
+			headers := [][2]string{
+				{"WARC-Type", "response"},
+				{"WARC-Record-ID", warcRecordID()},
+				{"WARC-Date", date.Format(time.RFC3339Nano)},
+				{"WARC-Target-URI", req.Request.URL},
+				{"WARC-Concurrent-To", requestRecordID},
+				{"WARC-Warcinfo-ID", warcinfoID},
+				{"WARC-Payload-Digest", warcDigest(body)},
+				{"Content-Type", "application/http;msgtype=response"},
+			}
+			if ip := strings.Trim(resp.RemoteIPAddress, "[]"); ip != "" {
+				headers = append(headers, [2]string{"WARC-IP-Address", ip})
+			}
+			err = writeWARCRecord(f, warcRecord{
+				headers: headers,
+				block:   httpResponseBlock(resp, body),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeWARCRecord writes a record to w as its own gzip member, which is how tools
+// expect to find records in a .warc.gz file
+func writeWARCRecord(w io.Writer, r warcRecord) error {
+	var buf bytes.Buffer
+	buf.WriteString("WARC/1.1\r\n")
+	for _, h := range r.headers {
+		buf.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	buf.WriteString("Content-Length: " + strconv.Itoa(len(r.block)) + "\r\n\r\n")
+	buf.Write(r.block)
+	buf.WriteString("\r\n\r\n")
+
+	gz := gzip.NewWriter(w)
+	_, err := gz.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// httpRequestBlock reconstructs the HTTP/1.1 request message sent by the browser
+func httpRequestBlock(req *network.Request, headers network.Headers) []byte {
+	target := "/"
+	host := ""
+	u, err := url.Parse(req.URL)
+	if err == nil {
+		target = u.RequestURI()
+		host = u.Host
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(req.Method + " " + target + " HTTP/1.1\r\n")
+	hasHost := false
+	for _, h := range httpHeaderLines(headers, nil) {
+		if strings.EqualFold(h[0], "host") {
+			hasHost = true
+		}
+		// HTTP/2 pseudo-headers (":method", ":path", etc.) have no place in an HTTP/1.1 message
+		if strings.HasPrefix(h[0], ":") {
+			continue
+		}
+		buf.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	if !hasHost && host != "" {
+		buf.WriteString("Host: " + host + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(req.PostData)
+
+	return buf.Bytes()
+}
+
+// httpResponseBlock reconstructs the HTTP/1.1 response message received by the browser
+func httpResponseBlock(resp *network.Response, body []byte) []byte {
+	statusText := resp.StatusText
+	if statusText == "" {
+		statusText = http.StatusText(int(resp.Status))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", resp.Status, statusText))
+	for _, h := range httpHeaderLines(resp.Headers, warcStrippedResponseHeaders) {
+		buf.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	buf.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n")
+	buf.Write(body)
+
+	return buf.Bytes()
+}
+
+// httpHeaderLines flattens DevTools headers into sorted name/value pairs, skipping any excluded headers
+func httpHeaderLines(headers network.Headers, exclude []string) [][2]string {
+	result := make([][2]string, 0, len(headers))
+	for name, value := range headers {
+		excluded := false
+		for _, e := range exclude {
+			if strings.EqualFold(name, e) {
+				excluded = true
+			}
+		}
+		if excluded {
+			continue
+		}
+
+		// Chromium joins repeated headers with newlines
+		for _, v := range strings.Split(fmt.Sprint(value), "\n") {
+			result = append(result, [2]string{name, v})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i][0] < result[j][0]
+	})
+
+	return result
+}
+
+// requestStartTime returns the (monotonic) time at which the browser sent a request
+func requestStartTime(req *network.EventRequestWillBeSent) time.Time {
+	if req.Timestamp == nil {
+		return time.Time{}
+	}
+	return req.Timestamp.Time()
+}
+
+func warcRecordID() string {
+	return "<urn:uuid:" + uuid.New().String() + ">"
+}
+
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
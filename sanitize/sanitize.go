@@ -2,6 +2,8 @@ package sanitize
 
 import (
 	"errors"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -11,8 +13,20 @@ import (
 	"os/user"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	// Header names must be tokens (RFC 7230)
+	headerNameRegexp = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+	// A comma-separated list of language ranges, each optionally weighted (e.g., "de-DE,de;q=0.9,*;q=0.5")
+	acceptLanguageRegexp = regexp.MustCompile(`^(\*|[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*)(;q=[01](\.[0-9]{0,3})?)?` +
+		`(\s*,\s*(\*|[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*)(;q=[01](\.[0-9]{0,3})?)?)*$`)
 )
 
 // Task takes a raw tasks, checks it for validity, adds default values as needed,
@@ -59,7 +73,12 @@ func Task(rt *b.RawTask) (b.TaskWrapper, error) {
 		return b.TaskWrapper{}, err
 	}
 
-	tw.SanitizedTask.BrowserFlags, err = getBrowserFlags(rt, tw.Log)
+	tw.SanitizedTask.Proxy, err = proxy(rt)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	tw.SanitizedTask.BrowserFlags, err = getBrowserFlags(rt, tw.SanitizedTask.Proxy, tw.Log)
 	if err != nil {
 		return b.TaskWrapper{}, err
 	}
@@ -91,6 +110,56 @@ func Task(rt *b.RawTask) (b.TaskWrapper, error) {
 		return b.TaskWrapper{}, err
 	}
 
+	tw.SanitizedTask.RequestRules, err = RequestRules(rt.RequestRules)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	tw.SanitizedTask.FilterLists, tw.SanitizedTask.BlockFilterMatches, err = filterLists(rt)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	tw.SanitizedTask.ReplayDirectory, err = replayDirectory(rt)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	tw.SanitizedTask.Emulation, err = emulation(rt)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	tw.SanitizedTask.NetworkConditions, err = networkConditions(rt.NetworkConditions)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	err = headerOverrides(rt, &tw.SanitizedTask)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	tw.SanitizedTask.SeedCookies, err = seedCookies(rt.SeedCookies, tw.SanitizedTask.URL)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	tw.SanitizedTask.SeedLocalStorage, err = seedLocalStorage(rt.SeedLocalStorage)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	tw.SanitizedTask.InitScripts, err = initScripts(rt)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
+	tw.SanitizedTask.PostLoadScripts, err = postLoadScripts(rt.PostLoadScripts)
+	if err != nil {
+		return b.TaskWrapper{}, err
+	}
+
 	return tw, nil
 }
 
@@ -119,6 +188,17 @@ func InteractionSettings(rt *b.RawTask) (b.InteractionSettings, error) {
 		*result.TriggerEventListeners = *is.TriggerEventListeners
 	}
 
+	if is.FollowPopups != nil {
+		*result.FollowPopups = *is.FollowPopups
+	}
+
+	if is.MaxPopups != nil {
+		if *is.MaxPopups < 0 {
+			return b.InteractionSettings{}, errors.New("max_popups value must be non-negative")
+		}
+		*result.MaxPopups = *is.MaxPopups
+	}
+
 	return *result, nil
 
 }
@@ -209,8 +289,9 @@ func getBrowserBinaryPath(rt *b.RawTask, log *logrus.Logger) (string, error) {
 
 // getBrowserFlags uses the flag and extension settings passed in in the RawTask to create a single string
 // slice with the flags we will use for our browser. Note that this slice will not include the specific
-// flag which allows remote control of the browser. This flag will be added in Stage 3.
-func getBrowserFlags(rt *b.RawTask, log *logrus.Logger) ([]string, error) {
+// flag which allows remote control of the browser. This flag will be added in Stage 3. The proxy
+// flags (if any) are always included, even when the browser flags are overridden.
+func getBrowserFlags(rt *b.RawTask, proxy *b.SanitizedProxy, log *logrus.Logger) ([]string, error) {
 	result := make([]string, 0)
 
 	if rt.Browser == nil {
@@ -277,6 +358,63 @@ func getBrowserFlags(rt *b.RawTask, log *logrus.Logger) ([]string, error) {
 		}
 	}
 
+	if proxy != nil {
+		result = append(result, "--proxy-server="+proxy.URL)
+		if len(proxy.Bypass) > 0 {
+			result = append(result, "--proxy-bypass-list="+strings.Join(proxy.Bypass, ";"))
+		}
+	}
+
+	return result, nil
+}
+
+// proxy validates the proxy settings for the task (if any). Credentials may be given either
+// explicitly or as part of the proxy URL, but the browser only accepts them for HTTP(S) proxies.
+func proxy(rt *b.RawTask) (*b.SanitizedProxy, error) {
+	if rt.Browser == nil || rt.Browser.Proxy == nil || rt.Browser.Proxy.URL == nil || *rt.Browser.Proxy.URL == "" {
+		return nil, nil
+	}
+	ps := rt.Browser.Proxy
+
+	u, err := url.Parse(*ps.URL)
+	if err != nil {
+		return nil, errors.New("invalid proxy url: " + err.Error())
+	}
+	switch u.Scheme {
+	case "http", "https", "socks4", "socks5":
+	default:
+		return nil, errors.New("unsupported proxy scheme (must be http, https, socks4 or socks5): " + u.Scheme)
+	}
+	if u.Hostname() == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("invalid proxy url (expected scheme://host[:port]): " + *ps.URL)
+	}
+
+	result := &b.SanitizedProxy{
+		URL: u.Scheme + "://" + u.Host,
+	}
+	if u.User != nil {
+		result.Username = u.User.Username()
+		result.Password, _ = u.User.Password()
+	}
+	if ps.Username != nil {
+		result.Username = *ps.Username
+	}
+	if ps.Password != nil {
+		result.Password = *ps.Password
+	}
+	if (result.Username != "" || result.Password != "") && strings.HasPrefix(u.Scheme, "socks") {
+		return nil, errors.New("proxy authentication is only supported for http and https proxies")
+	}
+
+	if ps.Bypass != nil {
+		for _, host := range *ps.Bypass {
+			if host == "" || strings.ContainsAny(host, ";=, \t") {
+				return nil, errors.New("invalid proxy bypass entry: \"" + host + "\"")
+			}
+			result.Bypass = append(result.Bypass, host)
+		}
+	}
+
 	return result, nil
 }
 
@@ -383,6 +521,47 @@ func DataSettings(rawDataSettings *b.DataSettings, parentSettings *b.DataSetting
 		*result.Screenshot = *rawDataSettings.Screenshot
 	}
 
+	*result.ScreenshotFullPage = b.DefaultScreenshotFullPage
+	if parentSettings != nil && parentSettings.ScreenshotFullPage != nil {
+		*result.ScreenshotFullPage = *parentSettings.ScreenshotFullPage
+	}
+	if rawDataSettings != nil && rawDataSettings.ScreenshotFullPage != nil {
+		*result.ScreenshotFullPage = *rawDataSettings.ScreenshotFullPage
+	}
+
+	*result.ScreenshotInterval = b.DefaultScreenshotInterval
+	if parentSettings != nil && parentSettings.ScreenshotInterval != nil {
+		*result.ScreenshotInterval = *parentSettings.ScreenshotInterval
+	}
+	if rawDataSettings != nil && rawDataSettings.ScreenshotInterval != nil {
+		*result.ScreenshotInterval = *rawDataSettings.ScreenshotInterval
+	}
+	if *result.ScreenshotInterval < 0 {
+		return b.DataSettings{}, errors.New("screenshot_interval value must be non-negative")
+	}
+
+	*result.ScreenshotFormat = b.DefaultScreenshotFormat
+	if parentSettings != nil && parentSettings.ScreenshotFormat != nil {
+		*result.ScreenshotFormat = *parentSettings.ScreenshotFormat
+	}
+	if rawDataSettings != nil && rawDataSettings.ScreenshotFormat != nil {
+		*result.ScreenshotFormat = strings.ToLower(*rawDataSettings.ScreenshotFormat)
+	}
+	if *result.ScreenshotFormat != "png" && *result.ScreenshotFormat != "jpeg" && *result.ScreenshotFormat != "webp" {
+		return b.DataSettings{}, errors.New("invalid screenshot format: " + *result.ScreenshotFormat)
+	}
+
+	*result.ScreenshotQuality = b.DefaultScreenshotQuality
+	if parentSettings != nil && parentSettings.ScreenshotQuality != nil {
+		*result.ScreenshotQuality = *parentSettings.ScreenshotQuality
+	}
+	if rawDataSettings != nil && rawDataSettings.ScreenshotQuality != nil {
+		*result.ScreenshotQuality = *rawDataSettings.ScreenshotQuality
+	}
+	if *result.ScreenshotQuality < 0 || *result.ScreenshotQuality > 100 {
+		return b.DataSettings{}, errors.New("screenshot_quality value must be between 0 and 100")
+	}
+
 	*result.Cookies = b.DefaultCookies
 	if parentSettings != nil && parentSettings.Cookies != nil {
 		*result.Cookies = *parentSettings.Cookies
@@ -399,6 +578,118 @@ func DataSettings(rawDataSettings *b.DataSettings, parentSettings *b.DataSetting
 		*result.DOM = *rawDataSettings.DOM
 	}
 
+	*result.WebSocket = b.DefaultWebSocket
+	if parentSettings != nil && parentSettings.WebSocket != nil {
+		*result.WebSocket = *parentSettings.WebSocket
+	}
+	if rawDataSettings != nil && rawDataSettings.WebSocket != nil {
+		*result.WebSocket = *rawDataSettings.WebSocket
+	}
+
+	*result.EventSourceMessages = b.DefaultEventSource
+	if parentSettings != nil && parentSettings.EventSourceMessages != nil {
+		*result.EventSourceMessages = *parentSettings.EventSourceMessages
+	}
+	if rawDataSettings != nil && rawDataSettings.EventSourceMessages != nil {
+		*result.EventSourceMessages = *rawDataSettings.EventSourceMessages
+	}
+
+	*result.HAR = b.DefaultHAR
+	if parentSettings != nil && parentSettings.HAR != nil {
+		*result.HAR = *parentSettings.HAR
+	}
+	if rawDataSettings != nil && rawDataSettings.HAR != nil {
+		*result.HAR = *rawDataSettings.HAR
+	}
+
+	*result.WARC = b.DefaultWARC
+	if parentSettings != nil && parentSettings.WARC != nil {
+		*result.WARC = *parentSettings.WARC
+	}
+	if rawDataSettings != nil && rawDataSettings.WARC != nil {
+		*result.WARC = *rawDataSettings.WARC
+	}
+
+	*result.Console = b.DefaultConsole
+	if parentSettings != nil && parentSettings.Console != nil {
+		*result.Console = *parentSettings.Console
+	}
+	if rawDataSettings != nil && rawDataSettings.Console != nil {
+		*result.Console = *rawDataSettings.Console
+	}
+
+	*result.JSCoverage = b.DefaultJSCoverage
+	if parentSettings != nil && parentSettings.JSCoverage != nil {
+		*result.JSCoverage = *parentSettings.JSCoverage
+	}
+	if rawDataSettings != nil && rawDataSettings.JSCoverage != nil {
+		*result.JSCoverage = *rawDataSettings.JSCoverage
+	}
+
+	*result.Screencast = b.DefaultScreencast
+	if parentSettings != nil && parentSettings.Screencast != nil {
+		*result.Screencast = *parentSettings.Screencast
+	}
+	if rawDataSettings != nil && rawDataSettings.Screencast != nil {
+		*result.Screencast = *rawDataSettings.Screencast
+	}
+
+	*result.ScreencastGIF = b.DefaultScreencastGIF
+	if parentSettings != nil && parentSettings.ScreencastGIF != nil {
+		*result.ScreencastGIF = *parentSettings.ScreencastGIF
+	}
+	if rawDataSettings != nil && rawDataSettings.ScreencastGIF != nil {
+		*result.ScreencastGIF = *rawDataSettings.ScreencastGIF
+	}
+
+	*result.MHTML = b.DefaultMHTML
+	if parentSettings != nil && parentSettings.MHTML != nil {
+		*result.MHTML = *parentSettings.MHTML
+	}
+	if rawDataSettings != nil && rawDataSettings.MHTML != nil {
+		*result.MHTML = *rawDataSettings.MHTML
+	}
+
+	*result.DOMSnapshot = b.DefaultDOMSnapshot
+	if parentSettings != nil && parentSettings.DOMSnapshot != nil {
+		*result.DOMSnapshot = *parentSettings.DOMSnapshot
+	}
+	if rawDataSettings != nil && rawDataSettings.DOMSnapshot != nil {
+		*result.DOMSnapshot = *rawDataSettings.DOMSnapshot
+	}
+
+	*result.DOMSnapshotStyles = append(*result.DOMSnapshotStyles, b.DefaultDOMSnapshotStyles...)
+	if parentSettings != nil && parentSettings.DOMSnapshotStyles != nil {
+		*result.DOMSnapshotStyles = domSnapshotStyles(*parentSettings.DOMSnapshotStyles)
+	}
+	if rawDataSettings != nil && rawDataSettings.DOMSnapshotStyles != nil {
+		*result.DOMSnapshotStyles = domSnapshotStyles(*rawDataSettings.DOMSnapshotStyles)
+	}
+
+	*result.WebStorage = b.DefaultWebStorage
+	if parentSettings != nil && parentSettings.WebStorage != nil {
+		*result.WebStorage = *parentSettings.WebStorage
+	}
+	if rawDataSettings != nil && rawDataSettings.WebStorage != nil {
+		*result.WebStorage = *rawDataSettings.WebStorage
+	}
+
+	*result.Frames = b.DefaultFrames
+	if parentSettings != nil && parentSettings.Frames != nil {
+		*result.Frames = *parentSettings.Frames
+	}
+	if rawDataSettings != nil && rawDataSettings.Frames != nil {
+		*result.Frames = *rawDataSettings.Frames
+	}
+
+	*result.Downloads = b.DefaultDownloads
+	if parentSettings != nil && parentSettings.Downloads != nil {
+		*result.Downloads = *parentSettings.Downloads
+	}
+	if rawDataSettings != nil && rawDataSettings.Downloads != nil {
+		*result.Downloads = *rawDataSettings.Downloads
+	}
+
 	*result.BrowserCoverage = b.DefaultBrowserCoverage
 	if parentSettings != nil && parentSettings.BrowserCoverage != nil {
 		*result.BrowserCoverage = *parentSettings.BrowserCoverage
@@ -545,6 +836,503 @@ func SftpOutputSettings(sos *b.SftpOutputSettings, defaultSettings *b.DataSettin
 	return result, nil
 }
 
+// RequestRules validates a list of raw request rules and compiles their URL patterns
+func RequestRules(rules *[]b.RequestRule) ([]b.SanitizedRequestRule, error) {
+	result := make([]b.SanitizedRequestRule, 0)
+	if rules == nil {
+		return result, nil
+	}
+
+	for i, rule := range *rules {
+		sr := b.SanitizedRequestRule{
+			Name:           strconv.Itoa(i),
+			ResourceTypes:  make([]string, 0),
+			Headers:        make(map[string]string),
+			FulfillStatus:  b.DefaultFulfillStatus,
+			FulfillHeaders: make(map[string]string),
+		}
+
+		if rule.Name != nil && *rule.Name != "" {
+			sr.Name = *rule.Name
+		}
+
+		if rule.URLGlob != nil && rule.URLRegex != nil {
+			return nil, errors.New("request rule " + sr.Name + " has both a url_glob and a url_regex")
+		}
+
+		var err error
+		if rule.URLGlob != nil {
+			sr.URLPattern, err = regexp.Compile(globToRegexp(*rule.URLGlob))
+		} else if rule.URLRegex != nil {
+			sr.URLPattern, err = regexp.Compile(*rule.URLRegex)
+		} else {
+			sr.URLPattern, err = regexp.Compile(".*")
+		}
+		if err != nil {
+			return nil, errors.New("invalid URL pattern for request rule " + sr.Name + ": " + err.Error())
+		}
+
+		if rule.ResourceTypes != nil {
+			sr.ResourceTypes = append(sr.ResourceTypes, *rule.ResourceTypes...)
+		}
+
+		if rule.Action == nil {
+			return nil, errors.New("request rule " + sr.Name + " is missing an action")
+		}
+		for _, a := range b.RequestRuleActions {
+			if a == *rule.Action {
+				sr.Action = a
+			}
+		}
+		if sr.Action == "" {
+			return nil, errors.New("invalid action for request rule " + sr.Name + ": " + string(*rule.Action))
+		}
+
+		switch sr.Action {
+		case b.RequestRuleRewriteHeaders:
+			if rule.Headers == nil || len(*rule.Headers) == 0 {
+				return nil, errors.New("request rule " + sr.Name + " rewrites headers but does not specify any")
+			}
+			for k, v := range *rule.Headers {
+				sr.Headers[k] = v
+			}
+		case b.RequestRuleFulfill:
+			if rule.FulfillFile == nil {
+				return nil, errors.New("request rule " + sr.Name + " fulfills requests but does not specify a file")
+			}
+			sr.FulfillFile = ExpandPath(*rule.FulfillFile)
+			_, err = os.Stat(sr.FulfillFile)
+			if err != nil {
+				return nil, errors.New("could not find fulfill file for request rule " + sr.Name + ": " + err.Error())
+			}
+
+			if rule.FulfillStatus != nil {
+				if *rule.FulfillStatus < 100 || *rule.FulfillStatus > 599 {
+					return nil, errors.New("invalid fulfill status for request rule " + sr.Name)
+				}
+				sr.FulfillStatus = *rule.FulfillStatus
+			}
+			if rule.FulfillHeaders != nil {
+				for k, v := range *rule.FulfillHeaders {
+					sr.FulfillHeaders[k] = v
+				}
+			}
+		}
+
+		result = append(result, sr)
+	}
+
+	return result, nil
+}
+
+// domSnapshotStyles merges the styles requested for DOM snapshots with the default ones, which we always need
+// to judge visibility. Duplicates are dropped, since styles are matched up with their values by position.
+func domSnapshotStyles(requested []string) []string {
+	result := make([]string, 0, len(requested)+len(b.DefaultDOMSnapshotStyles))
+	seen := make(map[string]bool)
+	for _, style := range append(append([]string{}, requested...), b.DefaultDOMSnapshotStyles...) {
+		style = strings.ToLower(strings.TrimSpace(style))
+		if style == "" || seen[style] {
+			continue
+		}
+		seen[style] = true
+		result = append(result, style)
+	}
+
+	return result
+}
+
+// filterLists validates the filter lists specified for the task (if any), returning their full paths along
+// with whether matching requests should be blocked
+func filterLists(rt *b.RawTask) ([]string, bool, error) {
+	result := make([]string, 0)
+	if rt.Browser == nil {
+		return result, false, nil
+	}
+
+	if rt.Browser.FilterLists != nil {
+		for _, fl := range *rt.Browser.FilterLists {
+			p, err := filepath.Abs(ExpandPath(fl))
+			if err != nil {
+				return nil, false, err
+			}
+			_, err = os.Stat(p)
+			if err != nil {
+				return nil, false, errors.New("could not find filter list: " + fl)
+			}
+			result = append(result, p)
+		}
+	}
+
+	block := rt.Browser.BlockFilterMatches != nil && *rt.Browser.BlockFilterMatches
+	if block && len(result) == 0 {
+		return nil, false, errors.New("block_filter_matches requires at least one filter list")
+	}
+
+	return result, block, nil
+}
+
+// initScripts checks that each of the init scripts for the task exists, returning their full paths
+func initScripts(rt *b.RawTask) ([]string, error) {
+	result := make([]string, 0)
+	if rt.Browser == nil || rt.Browser.InitScripts == nil {
+		return result, nil
+	}
+
+	for _, script := range *rt.Browser.InitScripts {
+		p, err := filepath.Abs(ExpandPath(script))
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, errors.New("could not find init script: " + script)
+		}
+		if info.IsDir() {
+			return nil, errors.New("init script [ " + script + " ] is a directory")
+		}
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+// postLoadScripts checks that each post-load script has a unique name and an expression to evaluate
+func postLoadScripts(scripts *[]b.PostLoadScript) ([]b.PostLoadScript, error) {
+	result := make([]b.PostLoadScript, 0)
+	if scripts == nil {
+		return result, nil
+	}
+
+	names := make(map[string]bool)
+	for i, script := range *scripts {
+		if script.Name == nil || *script.Name == "" {
+			return nil, errors.New("missing name for post-load script " + strconv.Itoa(i))
+		}
+		if names[*script.Name] {
+			return nil, errors.New("duplicate post-load script name: " + *script.Name)
+		}
+		names[*script.Name] = true
+
+		if script.Expression == nil || strings.TrimSpace(*script.Expression) == "" {
+			return nil, errors.New("missing expression for post-load script " + *script.Name)
+		}
+
+		result = append(result, script)
+	}
+
+	return result, nil
+}
+
+// emulation validates the emulation settings for the task (if any), starting from the named
+// device profile and applying any settings given explicitly on top of it
+func emulation(rt *b.RawTask) (*b.SanitizedEmulation, error) {
+	if rt.Browser == nil || rt.Browser.Emulation == nil {
+		return nil, nil
+	}
+	es := rt.Browser.Emulation
+
+	result := new(b.SanitizedEmulation)
+	if es.Device != nil && *es.Device != "" {
+		profile, ok := b.DefaultDeviceProfiles[*es.Device]
+		if !ok {
+			return nil, errors.New("unknown device profile: " + *es.Device)
+		}
+		*result = profile
+		result.Device = *es.Device
+	}
+
+	if es.Width != nil {
+		result.Width = *es.Width
+	}
+	if es.Height != nil {
+		result.Height = *es.Height
+	}
+	if es.DeviceScaleFactor != nil {
+		result.DeviceScaleFactor = *es.DeviceScaleFactor
+	}
+	if es.Mobile != nil {
+		result.Mobile = *es.Mobile
+	}
+	if es.Touch != nil {
+		result.Touch = *es.Touch
+	}
+	if es.UserAgent != nil {
+		result.UserAgent = *es.UserAgent
+
+		// Client hints from the profile would contradict a user agent given explicitly
+		result.ClientHints = nil
+	}
+	if es.ClientHints != nil {
+		result.ClientHints = es.ClientHints
+	}
+	if es.Timezone != nil {
+		result.Timezone = *es.Timezone
+	}
+	if es.Locale != nil {
+		result.Locale = *es.Locale
+	}
+	if es.Geolocation != nil {
+		result.Geolocation = es.Geolocation
+	}
+
+	if result.Width < 0 || result.Height < 0 || (result.Width == 0) != (result.Height == 0) {
+		return nil, errors.New("emulation width and height must both be positive (or both omitted)")
+	}
+	if result.DeviceScaleFactor < 0 {
+		return nil, errors.New("emulation device_scale_factor must be non-negative")
+	}
+	if result.Timezone != "" {
+		_, err := time.LoadLocation(result.Timezone)
+		if err != nil {
+			return nil, errors.New("invalid emulation timezone: " + result.Timezone)
+		}
+	}
+	if g := result.Geolocation; g != nil {
+		if g.Latitude < -90 || g.Latitude > 90 || g.Longitude < -180 || g.Longitude > 180 || g.Accuracy < 0 {
+			return nil, errors.New("invalid emulation geolocation")
+		}
+	}
+
+	return result, nil
+}
+
+// networkConditions validates the network conditions for the task (if any), starting from the
+// named profile and applying any settings given explicitly on top of it
+func networkConditions(nc *b.NetworkConditions) (*b.SanitizedNetworkConditions, error) {
+	if nc == nil {
+		return nil, nil
+	}
+
+	result := &b.SanitizedNetworkConditions{
+		CPUThrottlingRate: 1,
+	}
+	if nc.Profile != nil && *nc.Profile != "" {
+		profile, ok := b.DefaultNetworkProfiles[*nc.Profile]
+		if !ok {
+			return nil, errors.New("unknown network profile: " + *nc.Profile)
+		}
+		*result = profile
+		result.Profile = *nc.Profile
+	}
+
+	if nc.Offline != nil {
+		result.Offline = *nc.Offline
+	}
+	if nc.Latency != nil {
+		result.Latency = *nc.Latency
+	}
+	if nc.DownloadThroughput != nil {
+		result.DownloadThroughput = *nc.DownloadThroughput
+	}
+	if nc.UploadThroughput != nil {
+		result.UploadThroughput = *nc.UploadThroughput
+	}
+	if nc.CPUThrottlingRate != nil {
+		result.CPUThrottlingRate = *nc.CPUThrottlingRate
+	}
+
+	if result.Latency < 0 || result.DownloadThroughput < 0 || result.UploadThroughput < 0 {
+		return nil, errors.New("network latency and throughput values must be non-negative")
+	}
+	if result.CPUThrottlingRate < 1 {
+		return nil, errors.New("cpu_throttling_rate must be at least 1")
+	}
+
+	return result, nil
+}
+
+// headerOverrides validates the extra headers, user agent, Accept-Language and client hints for the task.
+// A user agent given in the browser settings takes precedence over the one from the emulated device
+// (along with its client hints). The emulation settings must already be sanitized.
+func headerOverrides(rt *b.RawTask, st *b.SanitizedTask) error {
+	if st.Emulation != nil {
+		st.UserAgent = st.Emulation.UserAgent
+		st.ClientHints = st.Emulation.ClientHints
+	}
+	if rt.Browser == nil {
+		return nil
+	}
+
+	if rt.Browser.UserAgent != nil {
+		st.UserAgent = *rt.Browser.UserAgent
+		st.ClientHints = nil
+	}
+	if rt.Browser.ClientHints != nil {
+		st.ClientHints = rt.Browser.ClientHints
+	}
+	if st.ClientHints != nil && st.UserAgent == "" {
+		return errors.New("client_hints require a user_agent")
+	}
+	if strings.ContainsAny(st.UserAgent, "\r\n") {
+		return errors.New("invalid user_agent: must not contain line breaks")
+	}
+
+	if rt.Browser.AcceptLanguage != nil && *rt.Browser.AcceptLanguage != "" {
+		if !acceptLanguageRegexp.MatchString(*rt.Browser.AcceptLanguage) {
+			return errors.New("invalid accept_language: " + *rt.Browser.AcceptLanguage)
+		}
+		st.AcceptLanguage = *rt.Browser.AcceptLanguage
+	}
+
+	if rt.Browser.ExtraHTTPHeaders != nil && len(*rt.Browser.ExtraHTTPHeaders) > 0 {
+		st.ExtraHTTPHeaders = make(map[string]string)
+		for name, value := range *rt.Browser.ExtraHTTPHeaders {
+			if !headerNameRegexp.MatchString(name) {
+				return errors.New("invalid extra header name: " + name)
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				return errors.New("invalid value for extra header " + name + ": must not contain line breaks")
+			}
+
+			// These are sent by the browser no matter what, so they have to be overridden through their own settings
+			switch strings.ToLower(name) {
+			case "user-agent":
+				return errors.New("set the user agent with user_agent rather than an extra header")
+			case "accept-language":
+				return errors.New("set Accept-Language with accept_language rather than an extra header")
+			}
+
+			st.ExtraHTTPHeaders[name] = value
+		}
+	}
+
+	return nil
+}
+
+// seedCookies validates the cookies to install before navigation. Cookies without a domain are
+// tied to the host of the task URL, just as if the page itself had set them.
+func seedCookies(cookies *[]b.SeedCookie, taskURL string) ([]*network.CookieParam, error) {
+	result := make([]*network.CookieParam, 0)
+	if cookies == nil {
+		return result, nil
+	}
+
+	for i, c := range *cookies {
+		if c.Name == nil || *c.Name == "" || strings.ContainsAny(*c.Name, "=;, \t\r\n") {
+			return nil, errors.New("invalid or missing name for seed cookie " + strconv.Itoa(i))
+		}
+		cp := &network.CookieParam{
+			Name: *c.Name,
+			Path: "/",
+		}
+		if c.Value != nil {
+			if strings.ContainsAny(*c.Value, ";\r\n") {
+				return nil, errors.New("invalid value for seed cookie " + cp.Name)
+			}
+			cp.Value = *c.Value
+		}
+		if c.Domain != nil && *c.Domain != "" {
+			cp.Domain = *c.Domain
+		} else {
+			cp.URL = taskURL
+		}
+		if c.Path != nil && *c.Path != "" {
+			if !strings.HasPrefix(*c.Path, "/") {
+				return nil, errors.New("path for seed cookie " + cp.Name + " must begin with \"/\"")
+			}
+			cp.Path = *c.Path
+		}
+		if c.Secure != nil {
+			cp.Secure = *c.Secure
+		}
+		if c.HTTPOnly != nil {
+			cp.HTTPOnly = *c.HTTPOnly
+		}
+		if c.SameSite != nil && *c.SameSite != "" {
+			switch network.CookieSameSite(*c.SameSite) {
+			case network.CookieSameSiteStrict, network.CookieSameSiteLax, network.CookieSameSiteNone:
+				cp.SameSite = network.CookieSameSite(*c.SameSite)
+			default:
+				return nil, errors.New("invalid same_site for seed cookie " + cp.Name + " (must be Strict, Lax or None)")
+			}
+			if cp.SameSite == network.CookieSameSiteNone && !cp.Secure {
+				return nil, errors.New("seed cookie " + cp.Name + " must be secure to use same_site None")
+			}
+		}
+		if c.Expires != nil {
+			expires := cdp.TimeSinceEpoch(time.Unix(0, int64(*c.Expires*float64(time.Second))))
+			cp.Expires = &expires
+		}
+
+		result = append(result, cp)
+	}
+
+	return result, nil
+}
+
+// seedLocalStorage validates the localStorage items to install before navigation, normalizing
+// the origins they are keyed by to the form the browser reports (scheme://host[:port])
+func seedLocalStorage(items *map[string]map[string]string) (map[string]map[string]string, error) {
+	if items == nil || len(*items) == 0 {
+		return nil, nil
+	}
+
+	result := make(map[string]map[string]string)
+	for origin, originItems := range *items {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") {
+			return nil, errors.New("invalid origin for seed local storage: " + origin)
+		}
+		host := u.Host
+		if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+			host = strings.TrimSuffix(host, ":"+u.Port())
+		}
+		normalized := strings.ToLower(u.Scheme + "://" + host)
+
+		if _, ok := result[normalized]; !ok {
+			result[normalized] = make(map[string]string)
+		}
+		for k, v := range originItems {
+			result[normalized][k] = v
+		}
+	}
+
+	return result, nil
+}
+
+// replayDirectory validates the results directory a task will replay responses from (if any)
+func replayDirectory(rt *b.RawTask) (string, error) {
+	if rt.Replay == nil || *rt.Replay == "" {
+		return "", nil
+	}
+
+	replayDir, err := filepath.Abs(ExpandPath(*rt.Replay))
+	if err != nil {
+		return "", err
+	}
+
+	// We need the resource metadata for headers. The resources themselves are optional, but without them
+	// every response will be replayed with an empty body.
+	_, err = os.Stat(path.Join(replayDir, b.DefaultResourceMetadataFile))
+	if err != nil {
+		return "", errors.New("replay directory is missing resource metadata: " + replayDir)
+	}
+
+	return replayDir, nil
+}
+
+// globToRegexp converts a URL glob, in which "*" matches any sequence of characters and "?" matches
+// any single character, into an anchored regular expression
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+
+	return sb.String()
+}
+
 // ValidateURL makes a best-effort pass at validating/fixing URLs
 func ValidateURL(s string) (string, error) {
 	var result string
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	b "github.com/teamnsrg/mida/base"
@@ -8,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 )
 
 // Local stores the results of a site visit locally, returning the path
@@ -50,7 +52,7 @@ func Local(finalResult *b.FinalResult, dataSettings *b.DataSettings, outPath str
 		}
 	}
 
-	if *dataSettings.ScriptMetadata {
+	if *dataSettings.ScriptMetadata || *dataSettings.JSCoverage {
 		data, err := json.Marshal(finalResult.DTScriptMetadata)
 		if err != nil {
 			return errors.New("failed to marshal script metadata for storage: " + err.Error())
@@ -62,6 +64,79 @@ func Local(finalResult *b.FinalResult, dataSettings *b.DataSettings, outPath str
 		}
 	}
 
+	if *dataSettings.WebSocket {
+		data, err := json.Marshal(finalResult.DTWebSockets)
+		if err != nil {
+			return errors.New("failed to marshal websocket data for storage: " + err.Error())
+		}
+
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultWebSocketFile), data, 0644)
+		if err != nil {
+			return errors.New("failed to write websocket file: " + err.Error())
+		}
+	}
+
+	if *dataSettings.EventSourceMessages {
+		data, err := json.Marshal(finalResult.DTEventSourceMessages)
+		if err != nil {
+			return errors.New("failed to marshal event source messages for storage: " + err.Error())
+		}
+
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultEventSourceFile), data, 0644)
+		if err != nil {
+			return errors.New("failed to write event source messages file: " + err.Error())
+		}
+	}
+
+	if *dataSettings.HAR && finalResult.HAR != nil {
+		data, err := json.Marshal(finalResult.HAR)
+		if err != nil {
+			return errors.New("failed to marshal HAR for storage: " + err.Error())
+		}
+
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultHARFile), data, 0644)
+		if err != nil {
+			return errors.New("failed to write HAR file: " + err.Error())
+		}
+	}
+
+	if *tw.SanitizedTask.IS.TriggerEventListeners {
+		data, err := json.Marshal(finalResult.DTEventListeners)
+		if err != nil {
+			return errors.New("failed to marshal event listeners for storage: " + err.Error())
+		}
+
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultEventListenerFile), data, 0644)
+		if err != nil {
+			return errors.New("failed to write event listeners file: " + err.Error())
+		}
+	}
+
+	// Request rule hits are stored whenever the task has request rules, since they are part of the experiment
+	if len(tw.SanitizedTask.RequestRules) > 0 {
+		data, err := json.Marshal(finalResult.DTRequestRuleHits)
+		if err != nil {
+			return errors.New("failed to marshal request rule hits for storage: " + err.Error())
+		}
+
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultRequestRuleHitsFile), data, 0644)
+		if err != nil {
+			return errors.New("failed to write request rule hits file: " + err.Error())
+		}
+	}
+
+	if *dataSettings.Console {
+		data, err := json.Marshal(finalResult.DTConsole)
+		if err != nil {
+			return errors.New("failed to marshal console data for storage: " + err.Error())
+		}
+
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultConsoleFile), data, 0644)
+		if err != nil {
+			return errors.New("failed to write console file: " + err.Error())
+		}
+	}
+
 	if *dataSettings.AllResources {
 		err = os.Rename(path.Join(tw.TempDir, b.DefaultResourceSubdir), path.Join(outPath, b.DefaultResourceSubdir))
 		if err != nil {
@@ -79,9 +154,129 @@ func Local(finalResult *b.FinalResult, dataSettings *b.DataSettings, outPath str
 	}
 
 	if *dataSettings.Screenshot {
-		err = os.Rename(path.Join(tw.TempDir, b.DefaultScreenshotFileName), path.Join(outPath, b.DefaultScreenshotFileName))
+		// The layout follows the settings the screenshots were captured with, which these settings may override
+		if b.ScreenshotSeries(&tw.SanitizedTask.DS) {
+			err = os.Rename(path.Join(tw.TempDir, b.DefaultScreenshotSubdir), path.Join(outPath, b.DefaultScreenshotSubdir))
+			if err != nil {
+				tw.Log.Warn("no screenshots were gathered -- load event probably never fired")
+			}
+		} else {
+			err = os.Rename(path.Join(tw.TempDir, b.DefaultScreenshotFileName), path.Join(outPath, b.DefaultScreenshotFileName))
+			if err != nil {
+				tw.Log.Warn("screenshot was not gathered -- load event probably never fired")
+			}
+		}
+	}
+
+	if *dataSettings.Downloads {
+		err = os.Rename(path.Join(tw.TempDir, b.DefaultDownloadSubdir), path.Join(outPath, b.DefaultDownloadSubdir))
+		if err != nil {
+			tw.Log.Error("failed to copy downloads directory into results directory: " + err.Error())
+		} else {
+			data, err := json.Marshal(finalResult.DTDownloads)
+			if err != nil {
+				return errors.New("failed to marshal download metadata for storage: " + err.Error())
+			}
+			err = ioutil.WriteFile(path.Join(outPath, b.DefaultDownloadSubdir, b.DefaultDownloadIndexFile), data, 0644)
+			if err != nil {
+				return errors.New("failed to write download index file: " + err.Error())
+			}
+		}
+	}
+
+	if *tw.SanitizedTask.IS.FollowPopups {
+		err = storePopups(finalResult.DTPopups, path.Join(outPath, b.DefaultPopupSubdir))
+		if err != nil {
+			return errors.New("failed to store popups: " + err.Error())
+		}
+	}
+
+	if *dataSettings.Screencast {
+		err = os.Rename(path.Join(tw.TempDir, b.DefaultScreencastSubdir), path.Join(outPath, b.DefaultScreencastSubdir))
+		if err != nil {
+			tw.Log.Error("failed to copy screencast directory into results directory: " + err.Error())
+		}
+	}
+
+	if *dataSettings.Screencast && *dataSettings.ScreencastGIF {
+		err = os.Rename(path.Join(tw.TempDir, b.DefaultScreencastGIFFile), path.Join(outPath, b.DefaultScreencastGIFFile))
+		if err != nil {
+			tw.Log.Error("failed to copy screencast GIF into results directory: " + err.Error())
+		}
+	}
+
+	if *dataSettings.WARC {
+		err = os.Rename(path.Join(tw.TempDir, b.DefaultWARCFile), path.Join(outPath, b.DefaultWARCFile))
+		if err != nil {
+			tw.Log.Error("failed to copy WARC file into results directory: " + err.Error())
+		}
+	}
+
+	if *dataSettings.MHTML && finalResult.MHTML != "" {
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultMHTMLFile), []byte(finalResult.MHTML), 0644)
+		if err != nil {
+			return errors.New("failed to write MHTML file: " + err.Error())
+		}
+	}
+
+	if len(tw.SanitizedTask.PostLoadScripts) > 0 {
+		data, err := json.Marshal(finalResult.Summary.ScriptResults)
+		if err != nil {
+			return errors.New("failed to marshal script results for storage: " + err.Error())
+		}
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultScriptResultsFile), data, 0644)
+		if err != nil {
+			return errors.New("failed to write script results file: " + err.Error())
+		}
+	}
+
+	// Instrumentation records are written one per line, as they may be numerous
+	if len(tw.SanitizedTask.InitScripts) > 0 {
+		var buf bytes.Buffer
+		for _, record := range finalResult.DTInstrumentation {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return errors.New("failed to marshal instrumentation record for storage: " + err.Error())
+			}
+			buf.Write(data)
+			buf.WriteByte('\n')
+		}
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultInstrumentationFile), buf.Bytes(), 0644)
+		if err != nil {
+			return errors.New("failed to write instrumentation file: " + err.Error())
+		}
+	}
+
+	if *dataSettings.Frames {
+		data, err := json.Marshal(finalResult.DTFrames)
+		if err != nil {
+			return errors.New("failed to marshal frames for storage: " + err.Error())
+		}
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultFramesFile), data, 0644)
 		if err != nil {
-			tw.Log.Warn("screenshot was not gathered -- load event probably never fired")
+			return errors.New("failed to write frames file: " + err.Error())
+		}
+	}
+
+	if *dataSettings.WebStorage && finalResult.DTWebStorage != nil {
+		data, err := json.Marshal(finalResult.DTWebStorage)
+		if err != nil {
+			return errors.New("failed to marshal web storage for storage: " + err.Error())
+		}
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultWebStorageFile), data, 0644)
+		if err != nil {
+			return errors.New("failed to write web storage file: " + err.Error())
+		}
+	}
+
+	if *dataSettings.DOMSnapshot && finalResult.DTDOMSnapshot != nil {
+		data, err := json.Marshal(finalResult.DTDOMSnapshot)
+		if err != nil {
+			return errors.New("failed to marshal DOM snapshot for storage: " + err.Error())
+		}
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultDOMSnapshotFile), data, 0644)
+		if err != nil {
+			return errors.New("failed to write DOM snapshot file: " + err.Error())
 		}
 	}
 
@@ -131,3 +326,47 @@ func Local(finalResult *b.FinalResult, dataSettings *b.DataSettings, outPath str
 
 	return nil
 }
+
+// storePopups stores each popup as a child result in its own subdirectory of popupDir,
+// along with an index describing all of the popups
+func storePopups(popups []*b.DTPopup, popupDir string) error {
+	err := os.MkdirAll(popupDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(popups)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path.Join(popupDir, b.DefaultPopupIndexFile), data, 0644)
+	if err != nil {
+		return err
+	}
+
+	for _, popup := range popups {
+		outPath := path.Join(popupDir, strconv.Itoa(popup.Index))
+		err = os.MkdirAll(outPath, 0755)
+		if err != nil {
+			return err
+		}
+
+		data, err = json.Marshal(popup.ResourceMetadata)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(path.Join(outPath, b.DefaultResourceMetadataFile), data, 0644)
+		if err != nil {
+			return err
+		}
+
+		if popup.Screenshot != "" {
+			err = os.Rename(popup.Screenshot, path.Join(outPath, path.Base(popup.Screenshot)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"github.com/sirupsen/logrus"
+	b "github.com/teamnsrg/mida/base"
+	"github.com/teamnsrg/mida/sanitize"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestLocalScreenshotLayout makes sure screenshots are stored in the layout they were captured in, even when the
+// output settings ask for a different screenshot format than the task did
+func TestLocalScreenshotLayout(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "mida-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	screenshot := true
+	jpeg := "jpeg"
+	taskDS, err := sanitize.DataSettings(&b.DataSettings{Screenshot: &screenshot, ScreenshotFormat: &jpeg}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.ScreenshotSeries(&taskDS) {
+		t.Fatal("expected a jpeg screenshot to use the screenshots directory")
+	}
+
+	enable := true
+	png := "png"
+	los, err := sanitize.LocalOutputSettings(&b.LocalOutputSettings{
+		Enable: &enable,
+		Path:   &dir,
+		DS:     &b.DataSettings{ScreenshotFormat: &png},
+	}, &taskDS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir := path.Join(dir, "temp")
+	err = os.MkdirAll(path.Join(tempDir, b.DefaultScreenshotSubdir), 0744)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(tempDir, b.DefaultScreenshotSubdir, "0000.jpeg"), []byte("jpeg"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := os.Create(path.Join(tempDir, b.DefaultTaskLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tw := &b.TaskWrapper{
+		SanitizedTask: b.SanitizedTask{
+			DS: taskDS,
+			IS: *b.AllocateNewInteractionSettings(),
+		},
+		TempDir: tempDir,
+		Log:     logrus.New(),
+		LogFile: logFile,
+	}
+	finalResult := &b.FinalResult{Summary: b.TaskSummary{TaskWrapper: tw}}
+
+	outPath := path.Join(dir, "out")
+	err = Local(finalResult, los.DS, outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(path.Join(outPath, b.DefaultScreenshotSubdir, "0000.jpeg"))
+	if err != nil {
+		t.Fatal("screenshot was not stored: " + err.Error())
+	}
+}